@@ -3,15 +3,24 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"github.com/olkonon/shortener/internal/app/analytics"
 	"github.com/olkonon/shortener/internal/app/config"
 	"github.com/olkonon/shortener/internal/app/handler"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/metrics"
 	"github.com/olkonon/shortener/internal/app/router"
 	"github.com/olkonon/shortener/internal/app/storage"
+	_ "github.com/olkonon/shortener/internal/app/storage/all"
+	"github.com/olkonon/shortener/internal/app/storage/bolt"
+	"github.com/olkonon/shortener/internal/app/storage/cache"
 	"github.com/olkonon/shortener/internal/app/storage/db"
 	"github.com/olkonon/shortener/internal/app/storage/file"
 	"github.com/olkonon/shortener/internal/app/storage/memory"
+	"github.com/olkonon/shortener/internal/app/storage/tiered"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -19,29 +28,93 @@ import (
 
 func main() {
 	appConfig := config.Parse()
+	storageDSN := appConfig.StorageDSN()
+	pgDSN := postgresDSN(storageDSN)
+	//Команда управления схемой базы данных вместо запуска сервера
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		os.Exit(runMigrate(pgDSN, flag.Args()[1:]))
+	}
 
-	var storageBackend storage.Storage = memory.NewInMemory()
+	gen, err := newIDGenerator(appConfig, storageDSN)
+	if err != nil {
+		log.Fatal("ID generator config error: ", err)
+	}
 
-	if appConfig.DSN != "" {
-		storageBackend = db.NewDatabaseStore(appConfig.DSN)
-	} else if appConfig.StorageFilePath != "" {
-		storageBackend = file.NewFileStorage(appConfig.StorageFilePath)
+	//Хранилище выбирается по схеме DSN из реестра storage
+	backend, err := storage.Open(storageDSN, storage.Options{Generator: gen})
+	if err != nil {
+		log.Fatal("Storage config error: ", err)
+	}
+	if err = metrics.RegisterStorageGauges(backend); err != nil {
+		log.Fatal("Metrics register error: ", err)
+	}
+	//Метрики снимаются с хранилища под кешем, чтобы учитывать только реальные обращения
+	var storageBackend storage.Storage = metrics.NewStorage(backend)
+	if appConfig.FallbackFile != "" {
+		//ID в резервном журнале случайные с префиксом, чтобы не совпасть с занятыми в основном хранилище
+		randomGen, err := idgen.NewRandom(appConfig.IDLength)
+		if err != nil {
+			log.Fatal("Fallback ID generator config error: ", err)
+		}
+		fallbackGen := idgen.NewPrefixed(idgen.FallbackPrefix, randomGen)
+		fallback := file.NewFileStorage(appConfig.FallbackFile, fallbackGen,
+			file.WithDurability(appConfig.FileDurability, appConfig.FileSyncPeriod))
+		storageBackend = tiered.New(storageBackend, fallback, appConfig.FallbackCache, appConfig.FallbackRetry)
+	}
+	if appConfig.CacheSize > 0 {
+		cached := cache.New(storageBackend, appConfig.CacheSize, appConfig.CacheTTL)
+		err = metrics.RegisterGauge("cache", "entries", "Entries in storage cache.", func() float64 {
+			return float64(cached.Len())
+		})
+		if err != nil {
+			log.Fatal("Metrics register error: ", err)
+		}
+		storageBackend = cached
 	}
 
+	recorder := newClickRecorder(appConfig.ClickIPMode, storageDSN)
+
 	handlerConf := handler.Config{
-		BaseURL: appConfig.BaseURL,
-		DSN:     appConfig.DSN,
-		Store:   storageBackend,
+		BaseURL:           appConfig.BaseURL,
+		DSN:               pgDSN,
+		Store:             storageBackend,
+		Recorder:          recorder,
+		TrustProxyHeaders: appConfig.TrustProxyHeaders,
 	}
 	server := &http.Server{
 		Handler: router.New(handler.New(handlerConf)),
 		Addr:    appConfig.ListenAddress,
 	}
 
+	//Фоновое удаление истекших ссылок
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	go storage.RunReaper(reaperCtx, storageBackend, appConfig.ReaperInterval)
+	//Окончательное удаление удаленных ссылок после срока хранения, после него ссылка отдает 404 вместо 410
+	//Очистка идет через декораторы, чтобы кэш сбросил закэшированные удаления, статистика переходов удаляется в том же проходе
+	if _, ok := backend.(storage.Purger); ok && appConfig.DeletedRetain > 0 {
+		go storage.RunPurger(reaperCtx, storageBackend.(storage.Purger), appConfig.DeletedRetain, appConfig.ReaperInterval, recorder.Delete)
+	}
+
+	//Сжатие журнала по сигналу SIGUSR1 для хранилищ, которые это поддерживают
+	if compactor, ok := backend.(storage.Compactor); ok {
+		compactSigs := make(chan os.Signal, 1)
+		signal.Notify(compactSigs, syscall.SIGUSR1)
+		go func() {
+			for range compactSigs {
+				log.Info("Get OS signal [SIGUSR1], compacting storage...")
+				if err := compactor.Compact(); err != nil {
+					log.Error("Storage compaction error: ", err)
+				}
+			}
+		}()
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	shutdownFinishedChan := make(chan struct{})
 	go func() {
+		defer close(shutdownFinishedChan)
 		sig := <-sigs
 		log.Infof("Get OS signal [%s], terminating...", sig.String())
 		server.SetKeepAlivesEnabled(false)
@@ -49,11 +122,75 @@ func main() {
 			log.Error("HTTP server shutdown error: ", err)
 		}
 	}()
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		//ListenAndServe возвращается сразу, ждем завершения обработчиков, которые еще пишут в хранилище и статистику
+		<-shutdownFinishedChan
+	} else if err != nil {
 		log.Error("Start HTTP server error: ", err)
 	}
+	stopReaper()
 	//Корректно освобождаем ресурсы бэкенда
 	if err := storageBackend.Close(); err != nil {
 		log.Error("Close Storage error: ", err)
 	}
+	if err := recorder.Close(); err != nil {
+		log.Error("Close click recorder error: ", err)
+	}
+}
+
+// newClickRecorder создает запись статистики переходов в хранилище того же типа что и основной бэкенд
+func newClickRecorder(ipMode string, storageDSN string) *analytics.Recorder {
+	anonymizer, err := analytics.NewIPAnonymizer(ipMode)
+	if err != nil {
+		log.Fatal("Click stats config error: ", err)
+	}
+
+	var sink analytics.Sink = analytics.NewMemorySink(analytics.DefaultRingSize)
+	switch storage.Scheme(storageDSN) {
+	case file.Scheme, bolt.Scheme:
+		//Статистика пишется в отдельный файл рядом с файлом хранилища
+		var u *url.URL
+		if u, err = url.Parse(storageDSN); err == nil {
+			var path string
+			if path, err = storage.FilePath(u); err == nil {
+				sink, err = analytics.NewFileSink(path + analytics.FileSinkSuffix)
+			}
+		}
+	default:
+		if dsn := postgresDSN(storageDSN); dsn != "" {
+			sink, err = analytics.NewDatabaseSink(dsn)
+		}
+	}
+	if err != nil {
+		//Данная ошибка фатальна, так как означает что хранилище статистики недоступно
+		log.Fatal("Click stats storage error: ", err)
+	}
+	return analytics.NewRecorder(sink, anonymizer)
+}
+
+// newIDGenerator создает генератор ID из настроек. Счетчик хранит границу выданных номеров в файле,
+// без файла номера после перезапуска начинаются заново, что допустимо только для хранилища в памяти
+func newIDGenerator(appConfig config.Config, storageDSN string) (idgen.IDGenerator, error) {
+	if appConfig.IDGenerator != idgen.CounterGenerator {
+		return idgen.New(appConfig.IDGenerator, appConfig.IDLength)
+	}
+	if appConfig.IDCounterFile != "" {
+		return idgen.OpenCounter(idgen.DefaultCounterAlphabet, appConfig.IDCounterFile)
+	}
+	if storage.Scheme(storageDSN) != memory.Scheme {
+		return nil, errors.New("counter ID generator requires ID_COUNTER_FILE for persistent storage")
+	}
+	return idgen.New(appConfig.IDGenerator, appConfig.IDLength)
+}
+
+// postgresDSN возвращает DSN если хранилище - Postgres, иначе пустую строку
+func postgresDSN(storageDSN string) string {
+	scheme := storage.Scheme(storageDSN)
+	for _, val := range db.Schemes {
+		if scheme == val {
+			return storageDSN
+		}
+	}
+	return ""
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/storage/db"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: shortener -d <dsn> migrate up|down [steps]|status"
+
+// runMigrate выполняет команду migrate, возвращает код завершения процесса
+func runMigrate(dsn string, args []string) int {
+	if dsn == "" {
+		log.Error("Migrations require database DSN (-d or DATABASE_DSN)")
+		return 2
+	}
+	if len(args) == 0 {
+		log.Error(migrateUsage)
+		return 2
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Error("DB connect error: ", err)
+		return 1
+	}
+	defer conn.Close()
+
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		log.Error("DB migrations error: ", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Error("Migrate up error: ", err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations\n", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				log.Error(migrateUsage)
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Error("Migrate down error: ", err)
+			return 1
+		}
+		fmt.Printf("Reverted %d migrations\n", len(reverted))
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			log.Error("Migrate status error: ", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, val := range status {
+			appliedAt := "pending"
+			if !val.AppliedAt.IsZero() {
+				appliedAt = val.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", val.Version, val.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		log.Error(migrateUsage)
+		return 2
+	}
+	return 0
+}
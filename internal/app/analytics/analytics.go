@@ -0,0 +1,55 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// DayLayout формат даты для поденной статистики переходов
+const DayLayout = "2006-01-02"
+
+// Event событие перехода по сокращенной ссылке
+type Event struct {
+	Time      time.Time
+	ShortID   string
+	Referrer  string
+	UserAgent string
+	ClientIP  string
+}
+
+// Stats статистика переходов по ссылке: общее количество и количество по дням (UTC)
+type Stats struct {
+	Total int
+	Days  []DayStats
+}
+
+type DayStats struct {
+	Date   string
+	Clicks int
+}
+
+// Sink хранилище событий переходов
+type Sink interface {
+	//Write сохраняет пачку событий
+	Write(ctx context.Context, events []Event) error
+	//Stats возвращает статистику переходов по ID сокращенной ссылки
+	Stats(ctx context.Context, shortID string) (Stats, error)
+	//Delete удаляет события окончательно удаленных ссылок
+	Delete(ctx context.Context, shortIDs []string) error
+	//Close корректно завершает работу любого Sink
+	Close() error
+}
+
+// statsFromDays собирает Stats из количества переходов по дням, дни упорядочены по возрастанию
+func statsFromDays(days map[string]int) Stats {
+	result := Stats{Days: make([]DayStats, 0, len(days))}
+	for day, clicks := range days {
+		result.Total += clicks
+		result.Days = append(result.Days, DayStats{Date: day, Clicks: clicks})
+	}
+	sort.Slice(result.Days, func(i, j int) bool {
+		return result.Days[i].Date < result.Days[j].Date
+	})
+	return result
+}
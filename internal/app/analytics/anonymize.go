@@ -0,0 +1,55 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// Режимы анонимизации IP адреса клиента
+const (
+	IPModeNone     = "none"
+	IPModeTruncate = "truncate"
+	IPModeHash     = "hash"
+	IPModeDrop     = "drop"
+)
+
+// IPAnonymizer преобразует IP адрес клиента перед сохранением
+type IPAnonymizer func(ip string) string
+
+// NewIPAnonymizer создает анонимизатор IP адреса по имени режима
+func NewIPAnonymizer(mode string) (IPAnonymizer, error) {
+	switch mode {
+	case IPModeNone:
+		return func(ip string) string { return ip }, nil
+	case IPModeTruncate, "":
+		return truncateIP, nil
+	case IPModeHash:
+		return hashIP, nil
+	case IPModeDrop:
+		return func(string) string { return "" }, nil
+	}
+	return nil, fmt.Errorf("unknown IP anonymization mode %q", mode)
+}
+
+// truncateIP обнуляет младшие биты адреса: IPv4 до /24, IPv6 до /48
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// hashIP заменяет адрес его хэшем, позволяя различать клиентов без хранения адреса
+func hashIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(ip))
+	return hex.EncodeToString(sum[:8])
+}
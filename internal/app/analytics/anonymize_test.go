@@ -0,0 +1,43 @@
+package analytics
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+func TestNewIPAnonymizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{name: "Test none", mode: IPModeNone, ip: "192.168.1.17", want: "192.168.1.17"},
+		{name: "Test truncate IPv4", mode: IPModeTruncate, ip: "192.168.1.17", want: "192.168.1.0"},
+		{name: "Test truncate IPv6", mode: IPModeTruncate, ip: "2001:db8:85a3:1:2:8a2e:370:7334", want: "2001:db8:85a3::"},
+		{name: "Test truncate bad IP", mode: IPModeTruncate, ip: "bad", want: ""},
+		{name: "Test hash", mode: IPModeHash, ip: "192.168.1.17", want: hashIP("192.168.1.17")},
+		{name: "Test drop", mode: IPModeDrop, ip: "192.168.1.17", want: ""},
+		{name: "Test unknown mode", mode: "mask", wantErr: true},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			anonymize, err := NewIPAnonymizer(test.mode)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, anonymize(test.ip))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+)
+
+const InsertClick = `INSERT INTO clicks (short_url,clicked_at,referrer,user_agent,client_ip) VALUES ($1,$2,$3,$4,$5)`
+const DeleteClicks = `DELETE FROM clicks WHERE short_url=ANY($1)`
+const SelectClicksByDay = `SELECT to_char(clicked_at AT TIME ZONE 'UTC','YYYY-MM-DD'),count(*) FROM clicks
+	WHERE short_url=$1 GROUP BY 1;`
+
+// NewDatabaseSink открывает отдельное подключение к Postgres.
+// Таблица clicks создается миграцией хранилища db, которое открывается раньше
+func NewDatabaseSink(dsn string) (*DatabaseSink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DatabaseSink{db: db}, nil
+}
+
+// DatabaseSink хранит события переходов в таблице clicks
+type DatabaseSink struct {
+	db *sql.DB
+}
+
+func (ds *DatabaseSink) Write(ctx context.Context, events []Event) error {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//Откат транзакции если Commit не прошел
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, InsertClick)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		_, err = stmt.ExecContext(ctx, event.ShortID, event.Time, event.Referrer, event.UserAgent, event.ClientIP)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (ds *DatabaseSink) Stats(ctx context.Context, shortID string) (Stats, error) {
+	rows, err := ds.db.QueryContext(ctx, SelectClicksByDay, shortID)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	days := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var clicks int
+		if err = rows.Scan(&day, &clicks); err != nil {
+			return Stats{}, err
+		}
+		days[day] = clicks
+	}
+	return statsFromDays(days), rows.Err()
+}
+
+func (ds *DatabaseSink) Delete(ctx context.Context, shortIDs []string) error {
+	_, err := ds.db.ExecContext(ctx, DeleteClicks, pq.Array(shortIDs))
+	return err
+}
+
+func (ds *DatabaseSink) Close() error {
+	if ds.db != nil {
+		return ds.db.Close()
+	}
+	return nil
+}
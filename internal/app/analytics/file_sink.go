@@ -0,0 +1,192 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSinkSuffix суффикс файла событий переходов рядом с файлом хранилища ссылок
+const FileSinkSuffix = ".clicks"
+
+// NewFileSink открывает файл событий на дозапись и строит поденные счетчики по уже записанным событиям
+func NewFileSink(path string) (*FileSink, error) {
+	tmp := &FileSink{
+		path: path,
+		days: make(map[string]map[string]int),
+	}
+	if err := tmp.loadFromFile(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	tmp.f = f
+	return tmp, nil
+}
+
+// FileSink дописывает события в файл JSON строками, статистику держит в памяти
+type FileSink struct {
+	path string
+	f    *os.File
+	days map[string]map[string]int
+	lock sync.RWMutex
+}
+
+func (fs *FileSink) Write(_ context.Context, events []Event) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	w := bufio.NewWriter(fs.f)
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		fs.count(event)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	//Для аналитики потеря последних событий при сбое допустима, поэтому Sync на каждую пачку не вызывается
+	return nil
+}
+
+func (fs *FileSink) Stats(_ context.Context, shortID string) (Stats, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	return statsFromDays(fs.days[shortID]), nil
+}
+
+// Delete переписывает файл без событий удаленных ссылок и атомарно заменяет им прежний
+func (fs *FileSink) Delete(_ context.Context, shortIDs []string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	deleted := false
+	for _, id := range shortIDs {
+		if _, isExists := fs.days[id]; isExists {
+			delete(fs.days, id)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+
+	tmpPath := fs.path + ".tmp"
+	if err := fs.writeFiltered(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	f, err := os.OpenFile(fs.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err = fs.f.Close(); err != nil {
+		log.Error("Close file error:", err)
+	}
+	fs.f = f
+	return nil
+}
+
+// writeFiltered записывает в path события ссылок, оставшихся в счетчиках, вызывается под блокировкой
+func (fs *FileSink) writeFiltered(path string) error {
+	src, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+	for err == nil {
+		var data []byte
+		data, err = r.ReadBytes('\n')
+		var event Event
+		if len(data) == 0 || json.Unmarshal(data, &event) != nil {
+			continue
+		}
+		if _, isExists := fs.days[event.ShortID]; isExists {
+			//Недописанная последняя строка дополняется разделителем
+			if data[len(data)-1] != '\n' {
+				data = append(data, '\n')
+			}
+			_, err = w.Write(data)
+		}
+	}
+	if err == io.EOF {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (fs *FileSink) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.f != nil {
+		return fs.f.Close()
+	}
+	return nil
+}
+
+// count учитывает событие в поденных счетчиках, вызывается под блокировкой
+func (fs *FileSink) count(event Event) {
+	if _, isExists := fs.days[event.ShortID]; !isExists {
+		fs.days[event.ShortID] = make(map[string]int)
+	}
+	fs.days[event.ShortID][event.Time.UTC().Format(DayLayout)]++
+}
+
+func (fs *FileSink) loadFromFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Error("Close file error:", err)
+		}
+	}()
+	r := bufio.NewReader(f)
+	for {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		var event Event
+		if err = json.Unmarshal(data, &event); err != nil {
+			//Поврежденное событие не должно мешать запуску сервиса
+			log.Warn("Skip broken click event: ", err)
+			continue
+		}
+		fs.count(event)
+	}
+	return nil
+}
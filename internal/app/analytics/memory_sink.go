@@ -0,0 +1,88 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultRingSize размер кольцевого буфера событий в памяти по умолчанию
+const DefaultRingSize = 10000
+
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{
+		events: make([]Event, size),
+	}
+}
+
+// MemorySink хранит последние события в кольцевом буфере, статистика считается только по ним
+type MemorySink struct {
+	events []Event
+	next   int
+	full   bool
+	lock   sync.RWMutex
+}
+
+func (ms *MemorySink) Write(_ context.Context, events []Event) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for _, event := range events {
+		ms.events[ms.next] = event
+		ms.next++
+		if ms.next == len(ms.events) {
+			ms.next = 0
+			ms.full = true
+		}
+	}
+	return nil
+}
+
+func (ms *MemorySink) Stats(_ context.Context, shortID string) (Stats, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	count := ms.next
+	if ms.full {
+		count = len(ms.events)
+	}
+	days := make(map[string]int)
+	for _, event := range ms.events[:count] {
+		if event.ShortID == shortID {
+			days[event.Time.UTC().Format(DayLayout)]++
+		}
+	}
+	return statsFromDays(days), nil
+}
+
+func (ms *MemorySink) Delete(_ context.Context, shortIDs []string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	deleted := make(map[string]bool, len(shortIDs))
+	for _, id := range shortIDs {
+		deleted[id] = true
+	}
+	//Оставшиеся события переносятся в начало буфера в прежнем порядке
+	ordered := ms.events[:ms.next]
+	if ms.full {
+		ordered = append(append([]Event{}, ms.events[ms.next:]...), ms.events[:ms.next]...)
+	}
+	kept := make([]Event, 0, len(ordered))
+	for _, event := range ordered {
+		if !deleted[event.ShortID] {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) == len(ordered) {
+		return nil
+	}
+	ms.events = make([]Event, len(ms.events))
+	copy(ms.events, kept)
+	ms.next = len(kept)
+	ms.full = false
+	return nil
+}
+
+func (ms *MemorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// recorderBufferSize размер буфера событий, при переполнении события отбрасываются
+	recorderBufferSize = 1024
+	// recorderBatchSize максимальный размер пачки событий для записи в Sink
+	recorderBatchSize = 128
+	// recorderFlushInterval максимальное время ожидания перед записью неполной пачки
+	recorderFlushInterval = time.Second
+)
+
+func NewRecorder(sink Sink, anonymize IPAnonymizer) *Recorder {
+	tmp := &Recorder{
+		sink:             sink,
+		anonymize:        anonymize,
+		eventChan:        make(chan Event, recorderBufferSize),
+		stopFinishedChan: make(chan bool),
+	}
+	go tmp.worker()
+	return tmp
+}
+
+// Recorder асинхронно записывает события переходов в Sink пачками, не задерживая редирект
+type Recorder struct {
+	sink             Sink
+	anonymize        IPAnonymizer
+	eventChan        chan Event
+	stopFinishedChan chan bool
+	//closeLock не дает Record писать в закрытую очередь событий
+	closeLock sync.RWMutex
+	isClosed  bool
+}
+
+// Record ставит событие в очередь на запись, при переполнении буфера или после Close событие отбрасывается
+func (r *Recorder) Record(event Event) {
+	event.ClientIP = r.anonymize(event.ClientIP)
+	r.closeLock.RLock()
+	defer r.closeLock.RUnlock()
+	if r.isClosed {
+		log.Warn("Click recorder is closed, event dropped")
+		return
+	}
+	select {
+	case r.eventChan <- event:
+	default:
+		log.Warn("Click events buffer is full, event dropped")
+	}
+}
+
+// Stats возвращает статистику переходов по ID сокращенной ссылки
+func (r *Recorder) Stats(ctx context.Context, shortID string) (Stats, error) {
+	return r.sink.Stats(ctx, shortID)
+}
+
+// Delete удаляет события окончательно удаленных ссылок, чтобы ID, выданный заново, не получил чужую статистику
+func (r *Recorder) Delete(ctx context.Context, shortIDs []string) error {
+	return r.sink.Delete(ctx, shortIDs)
+}
+
+// Close дописывает все события из очереди и закрывает Sink, события записанные после Close отбрасываются
+func (r *Recorder) Close() error {
+	r.closeLock.Lock()
+	if r.isClosed {
+		r.closeLock.Unlock()
+		return nil
+	}
+	r.isClosed = true
+	close(r.eventChan)
+	r.closeLock.Unlock()
+	//Ждем пока воркер запишет оставшиеся события
+	<-r.stopFinishedChan
+	return r.sink.Close()
+}
+
+func (r *Recorder) worker() {
+	defer func() {
+		r.stopFinishedChan <- true
+	}()
+	ticker := time.NewTicker(recorderFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, recorderBatchSize)
+	for {
+		select {
+		case event, ok := <-r.eventChan:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= recorderBatchSize {
+				batch = r.flush(batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch)
+		}
+	}
+}
+
+// flush записывает пачку в Sink и возвращает пустую пачку для повторного использования
+func (r *Recorder) flush(batch []Event) []Event {
+	if len(batch) == 0 {
+		return batch
+	}
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.sink.Write(timeoutCtx, batch); err != nil {
+		log.Error("Write click events error: ", err)
+	}
+	return batch[:0]
+}
@@ -0,0 +1,174 @@
+package analytics
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Stats(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: day2, ShortID: "abc", ClientIP: "10.0.0.1"},
+		{Time: day1, ShortID: "abc", ClientIP: "10.0.0.2"},
+		{Time: day1, ShortID: "abc", ClientIP: "10.0.0.3"},
+		{Time: day1, ShortID: "other", ClientIP: "10.0.0.4"},
+	}
+	want := Stats{
+		Total: 3,
+		Days: []DayStats{
+			{Date: "2024-01-01", Clicks: 2},
+			{Date: "2024-01-02", Clicks: 1},
+		},
+	}
+
+	sink := NewMemorySink(DefaultRingSize)
+	anonymize, err := NewIPAnonymizer(IPModeTruncate)
+	require.NoError(t, err)
+	recorder := NewRecorder(sink, anonymize)
+	for _, event := range events {
+		recorder.Record(event)
+	}
+	//Close дописывает все события из очереди
+	err = recorder.Close()
+	require.NoError(t, err)
+
+	got, err := sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, "10.0.0.0", sink.events[0].ClientIP)
+}
+
+func TestRecorder_RecordAfterClose(t *testing.T) {
+	sink := NewMemorySink(DefaultRingSize)
+	anonymize, err := NewIPAnonymizer(IPModeTruncate)
+	require.NoError(t, err)
+	recorder := NewRecorder(sink, anonymize)
+	err = recorder.Close()
+	require.NoError(t, err)
+
+	//Переход, завершившийся после остановки, не должен ронять сервис
+	assert.NotPanics(t, func() {
+		recorder.Record(Event{Time: time.Now(), ShortID: "abc", ClientIP: "10.0.0.1"})
+	})
+	got, err := sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Total)
+	assert.NoError(t, recorder.Close())
+}
+
+func TestMemorySink_Ring(t *testing.T) {
+	sink := NewMemorySink(2)
+	now := time.Now()
+	err := sink.Write(context.Background(), []Event{
+		{Time: now, ShortID: "old"},
+		{Time: now, ShortID: "abc"},
+		{Time: now, ShortID: "abc"},
+	})
+	require.NoError(t, err)
+
+	//Самое старое событие вытеснено из буфера
+	got, err := sink.Stats(context.Background(), "old")
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Total)
+	got, err = sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Total)
+}
+
+func TestFileSink_Reload(t *testing.T) {
+	filename := "3F1B1E0C-6E1A-4C43-9B0B-1E7B0A4C5D21" + FileSinkSuffix
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	sink, err := NewFileSink(filename)
+	require.NoError(t, err)
+	day := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	err = sink.Write(context.Background(), []Event{
+		{Time: day, ShortID: "abc"},
+		{Time: day, ShortID: "abc"},
+	})
+	require.NoError(t, err)
+	err = sink.Close()
+	require.NoError(t, err)
+
+	//После перезапуска статистика восстанавливается из файла
+	sink, err = NewFileSink(filename)
+	require.NoError(t, err)
+	defer func() {
+		err := sink.Close()
+		require.NoError(t, err)
+	}()
+	got, err := sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Total: 2, Days: []DayStats{{Date: "2024-01-01", Clicks: 2}}}, got)
+}
+
+func TestMemorySink_Delete(t *testing.T) {
+	sink := NewMemorySink(3)
+	now := time.Now()
+	err := sink.Write(context.Background(), []Event{
+		{Time: now, ShortID: "old"},
+		{Time: now, ShortID: "purged"},
+		{Time: now, ShortID: "abc"},
+		{Time: now, ShortID: "purged"},
+	})
+	require.NoError(t, err)
+
+	err = sink.Delete(context.Background(), []string{"purged"})
+	require.NoError(t, err)
+	got, err := sink.Stats(context.Background(), "purged")
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Total)
+
+	//Освободившееся место занимают новые события, оставшиеся не вытесняются раньше времени
+	err = sink.Write(context.Background(), []Event{{Time: now, ShortID: "abc"}})
+	require.NoError(t, err)
+	got, err = sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Total)
+}
+
+func TestFileSink_Delete(t *testing.T) {
+	filename := "7C2D3E4F-5A6B-4C7D-8E9F-0A1B2C3D4E5F" + FileSinkSuffix
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	sink, err := NewFileSink(filename)
+	require.NoError(t, err)
+	day := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	err = sink.Write(context.Background(), []Event{
+		{Time: day, ShortID: "abc"},
+		{Time: day, ShortID: "purged"},
+	})
+	require.NoError(t, err)
+	err = sink.Delete(context.Background(), []string{"purged"})
+	require.NoError(t, err)
+	//Запись после удаления идет в новый файл
+	err = sink.Write(context.Background(), []Event{{Time: day, ShortID: "abc"}})
+	require.NoError(t, err)
+	err = sink.Close()
+	require.NoError(t, err)
+
+	//После перезапуска удаленные события не возвращаются
+	sink, err = NewFileSink(filename)
+	require.NoError(t, err)
+	defer func() {
+		err := sink.Close()
+		require.NoError(t, err)
+	}()
+	got, err := sink.Stats(context.Background(), "purged")
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Total)
+	got, err = sink.Stats(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Total)
+}
@@ -1,9 +1,20 @@
 package api
 
-import "github.com/olkonon/shortener/internal/app/common"
+import (
+	"github.com/olkonon/shortener/internal/app/common"
+	"time"
+)
 
 type AddURLRequest struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Alias     string `json:"alias,omitempty"`
+	MaxClicks int    `json:"max_clicks,omitempty"`
+	Expiration
+}
+
+// IsValid Проверка корректности URL, лимита переходов и псевдонима, если он задан
+func (ar *AddURLRequest) IsValid() bool {
+	return common.IsValidURL(ar.URL) && ar.MaxClicks >= 0 && (ar.Alias == "" || common.IsValidAlias(ar.Alias))
 }
 
 type AddURLResponse struct {
@@ -13,18 +24,47 @@ type AddURLResponse struct {
 type BatchAddURLRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	MaxClicks     int    `json:"max_clicks,omitempty"`
+	Expiration
 }
 
 func (br *BatchAddURLRequest) IsValid() bool {
-	return common.IsValidURL(br.OriginalURL)
+	return common.IsValidURL(br.OriginalURL) && br.MaxClicks >= 0
 }
 
 type BatchAddURLResponse struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	//Status результат сохранения: created, existed или restored
+	Status string `json:"status"`
 }
 
 type UserGetResponse struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 }
+
+type StatsResponse struct {
+	ShortURL string          `json:"short_url"`
+	Total    int             `json:"total"`
+	Days     []DayStatsEntry `json:"days"`
+}
+
+type DayStatsEntry struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+type UpdateURLRequest struct {
+	URL string `json:"url"`
+}
+
+// IsValid Проверка корректности нового адреса ссылки
+func (ur *UpdateURLRequest) IsValid() bool {
+	return common.IsValidURL(ur.URL)
+}
+
+type HistoryEntry struct {
+	OriginalURL string    `json:"original_url"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
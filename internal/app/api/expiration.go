@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBadExpiration говорит о том что срок жизни ссылки задан некорректно
+var ErrBadExpiration = errors.New("bad expiration")
+
+// Expiration параметры срока жизни сокращенной ссылки, задается либо время истечения, либо ttl в секундах
+type Expiration struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       int64      `json:"ttl,omitempty"`
+}
+
+// ExpiresTime вычисляет время истечения ссылки относительно now, нулевое значение - ссылка бессрочная
+func (e Expiration) ExpiresTime(now time.Time) (time.Time, error) {
+	switch {
+	case e.ExpiresAt != nil && e.TTL != 0:
+		return time.Time{}, ErrBadExpiration
+	case e.ExpiresAt != nil:
+		if !e.ExpiresAt.After(now) {
+			return time.Time{}, ErrBadExpiration
+		}
+		return *e.ExpiresAt, nil
+	case e.TTL < 0:
+		return time.Time{}, ErrBadExpiration
+	case e.TTL > 0:
+		return now.Add(time.Duration(e.TTL) * time.Second), nil
+	}
+	return time.Time{}, nil
+}
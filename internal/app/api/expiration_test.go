@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestExpiration_ExpiresTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		expiration Expiration
+		want       time.Time
+		wantErr    bool
+	}{
+		{
+			name:       "Test no expiration",
+			expiration: Expiration{},
+			want:       time.Time{},
+		},
+		{
+			name:       "Test TTL",
+			expiration: Expiration{TTL: 60},
+			want:       now.Add(time.Minute),
+		},
+		{
+			name:       "Test expires_at",
+			expiration: Expiration{ExpiresAt: &future},
+			want:       future,
+		},
+		{
+			name:       "Test expires_at in past",
+			expiration: Expiration{ExpiresAt: &past},
+			wantErr:    true,
+		},
+		{
+			name:       "Test negative TTL",
+			expiration: Expiration{TTL: -1},
+			wantErr:    true,
+		},
+		{
+			name:       "Test both TTL and expires_at",
+			expiration: Expiration{ExpiresAt: &future, TTL: 60},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.expiration.ExpiresTime(now)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrBadExpiration)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
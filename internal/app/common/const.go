@@ -1,12 +1,32 @@
 package common
 
+import "time"
+
 const (
 	DefaultBaseURL         = "http://localhost:8080"
 	DefaultListenAddress   = "localhost:8080"
 	DefaultStorageFilePath = ""
 	DefaultDBDSN           = ""
+	DefaultStorageURL      = ""
+	DefaultBoltFilePath    = ""
+	DefaultIDGenerator     = "hash"
+	DefaultIDCounterFile   = ""
+	DefaultClickIPMode     = "truncate"
+	DefaultTrustProxy      = false
+	DefaultCompactMinSize  = 1 << 20
+	DefaultCompactRatio    = 2.0
+	DefaultFileDurability  = "group"
+	DefaultFileSyncPeriod  = 100 * time.Millisecond
 	AnonymousUser          = "anonymous"
 	TestUser               = "test-user"
 	SessionCookieName      = "X-Session-Id"
 	MuxUserVarName         = "user-id"
+	DefaultReaperInterval  = time.Minute
+	DefaultDeletedRetain   = time.Duration(0)
+	MaxURLLength           = 8192
+	DefaultCacheSize       = 0
+	DefaultCacheTTL        = time.Minute
+	DefaultFallbackFile    = ""
+	DefaultFallbackCache   = 10000
+	DefaultFallbackRetry   = 5 * time.Second
 )
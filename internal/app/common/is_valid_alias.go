@@ -0,0 +1,27 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxAliasLength максимальная длина псевдонима сокращенной ссылки
+const MaxAliasLength = 32
+
+// ReservedAliases пути сервиса, которые нельзя занять псевдонимом
+var ReservedAliases = []string{"ping", "api", "metrics"}
+
+var aliasRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// IsValidAlias Проверка, что alias допустимый псевдоним сокращенной ссылки
+func IsValidAlias(alias string) bool {
+	if len(alias) == 0 || len(alias) > MaxAliasLength || !aliasRegexp.MatchString(alias) {
+		return false
+	}
+	for _, reserved := range ReservedAliases {
+		if strings.EqualFold(alias, reserved) {
+			return false
+		}
+	}
+	return true
+}
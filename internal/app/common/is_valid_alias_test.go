@@ -0,0 +1,63 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestIsValidAlias(t *testing.T) {
+	tests := []struct {
+		name         string
+		alias        string
+		isValidAlias bool
+	}{
+		{
+			name:         "Test empty alias",
+			alias:        "",
+			isValidAlias: false,
+		},
+		{
+			name:         "Test reserved alias #1",
+			alias:        "ping",
+			isValidAlias: false,
+		},
+		{
+			name:         "Test reserved alias #2",
+			alias:        "API",
+			isValidAlias: false,
+		},
+		{
+			name:         "Test reserved alias #3",
+			alias:        "metrics",
+			isValidAlias: false,
+		},
+		{
+			name:         "Test bad symbols",
+			alias:        "q3/report",
+			isValidAlias: false,
+		},
+		{
+			name:         "Test too long alias",
+			alias:        strings.Repeat("a", MaxAliasLength+1),
+			isValidAlias: false,
+		},
+		{
+			name:         "Test right alias #1",
+			alias:        "q3-report",
+			isValidAlias: true,
+		},
+		{
+			name:         "Test right alias #2",
+			alias:        "Team_2024",
+			isValidAlias: true,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		f := func(t *testing.T) {
+			assert.Equal(t, test.isValidAlias, IsValidAlias(test.alias))
+		}
+		t.Run(test.name, f)
+	}
+}
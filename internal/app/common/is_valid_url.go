@@ -2,8 +2,11 @@ package common
 
 import "net/url"
 
-// IsValidURL Проверка, что data валидный URL
+// IsValidURL Проверка, что data валидный URL не длиннее MaxURLLength
 func IsValidURL(data string) bool {
+	if len(data) > MaxURLLength {
+		return false
+	}
 	u, err := url.ParseRequestURI(data)
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
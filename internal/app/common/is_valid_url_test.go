@@ -4,6 +4,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +38,11 @@ func TestIsValidURL(t *testing.T) {
 			data:       "http:///test",
 			isValidURL: false,
 		},
+		{
+			name:       "Test fail URL #5",
+			data:       "http://test.com/" + strings.Repeat("a", MaxURLLength),
+			isValidURL: false,
+		},
 		{
 			name:       "Test right URL #1",
 			data:       "http://test.com/test",
@@ -47,6 +53,11 @@ func TestIsValidURL(t *testing.T) {
 			data:       "http://test.com/test?v=3",
 			isValidURL: true,
 		},
+		{
+			name:       "Test right URL #3",
+			data:       "http://test.com/?utm=" + strings.Repeat("a", 1000),
+			isValidURL: true,
+		},
 	}
 	for _, tt := range tests {
 		test := tt
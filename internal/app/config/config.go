@@ -3,32 +3,114 @@ package config
 import (
 	"flag"
 	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	log "github.com/sirupsen/logrus"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
-	BaseURL         string
-	ListenAddress   string
-	StorageFilePath string
-	DSN             string
+	BaseURL           string
+	ListenAddress     string
+	StorageFilePath   string
+	BoltFilePath      string
+	CompactMinSize    int
+	CompactRatio      float64
+	FileDurability    string
+	FileSyncPeriod    time.Duration
+	DSN               string
+	StorageURL        string
+	CacheSize         int
+	CacheTTL          time.Duration
+	FallbackFile      string
+	FallbackCache     int
+	FallbackRetry     time.Duration
+	IDGenerator       string
+	IDLength          int
+	IDCounterFile     string
+	ReaperInterval    time.Duration
+	DeletedRetain     time.Duration
+	ClickIPMode       string
+	TrustProxyHeaders bool
 }
 
 func Parse() Config {
 	address := flag.String("a", common.DefaultListenAddress, "Listen server address, default "+common.DefaultListenAddress)
 	baseURL := flag.String("b", common.DefaultBaseURL, "Short URL base address, default "+common.DefaultBaseURL)
 	filePath := flag.String("f", common.DefaultStorageFilePath, "File path for base file storage, default "+common.DefaultStorageFilePath)
+	compactMinSize := flag.Int("compact-min-size", common.DefaultCompactMinSize, "Min file storage size in bytes for auto compaction, 0 disables, default "+strconv.Itoa(common.DefaultCompactMinSize))
+	compactRatio := flag.Float64("compact-ratio", common.DefaultCompactRatio, "File storage records to live records ratio for auto compaction, default "+strconv.FormatFloat(common.DefaultCompactRatio, 'f', -1, 64))
+	fileDurability := flag.String("file-durability", common.DefaultFileDurability, "File storage durability mode: always, group or interval, default "+common.DefaultFileDurability)
+	fileSyncPeriod := flag.Duration("file-sync-interval", common.DefaultFileSyncPeriod, "File storage fsync period for interval durability mode, default "+common.DefaultFileSyncPeriod.String())
+	boltPath := flag.String("s", common.DefaultBoltFilePath, "File path for embedded bbolt storage, default "+common.DefaultBoltFilePath)
+	storageURL := flag.String("storage", common.DefaultStorageURL, "Storage URL: memory://, file:///path, bolt:///path or postgres://..., overrides -d, -s and -f")
+	cacheSize := flag.Int("cache-size", common.DefaultCacheSize, "Redirect cache size in links, 0 disables cache, default "+strconv.Itoa(common.DefaultCacheSize))
+	cacheTTL := flag.Duration("cache-ttl", common.DefaultCacheTTL, "Redirect cache entry TTL, default "+common.DefaultCacheTTL.String())
+	fallbackFile := flag.String("fallback-file", common.DefaultFallbackFile, "Fallback journal file for writes while storage is unavailable, empty disables fallback")
+	fallbackCache := flag.Int("fallback-cache-size", common.DefaultFallbackCache, "Links served from memory while storage is unavailable, default "+strconv.Itoa(common.DefaultFallbackCache))
+	fallbackRetry := flag.Duration("fallback-retry-interval", common.DefaultFallbackRetry, "Fallback journal replay interval, default "+common.DefaultFallbackRetry.String())
 	dsn := flag.String("d", common.DefaultDBDSN, "DB connection URL "+common.DefaultDBDSN)
+	idGenerator := flag.String("g", common.DefaultIDGenerator, "Short ID generator: hash, random, counter or ulid, default "+common.DefaultIDGenerator)
+	reaperInterval := flag.Duration("r", common.DefaultReaperInterval, "Expired links reaper interval, default "+common.DefaultReaperInterval.String())
+	deletedRetain := flag.Duration("deleted-retention", common.DefaultDeletedRetain, "Retention of deleted links before permanent removal, 0 keeps them forever")
+	clickIPMode := flag.String("i", common.DefaultClickIPMode, "Client IP anonymization in click stats: none, truncate, hash or drop, default "+common.DefaultClickIPMode)
+	trustProxy := flag.Bool("trust-proxy", common.DefaultTrustProxy, "Take client IP from X-Real-IP and X-Forwarded-For headers, enable only behind a trusted proxy")
+	idLength := flag.Int("l", idgen.DefaultRandomLength, "Short ID length for random generator, default "+strconv.Itoa(idgen.DefaultRandomLength))
+	idCounterFile := flag.String("id-counter-file", common.DefaultIDCounterFile, "File with issued ID bound for counter generator, required unless storage is memory://")
 	// делаем разбор командной строки
 	flag.Parse()
 
 	return Config{
-		BaseURL:         mergeSetting(*baseURL, "BASE_URL"),
-		ListenAddress:   mergeSetting(*address, "SERVER_ADDRESS"),
-		StorageFilePath: mergeSetting(*filePath, "FILE_STORAGE_PATH"),
-		DSN:             mergeSetting(*dsn, "DATABASE_DSN"),
+		BaseURL:           mergeSetting(*baseURL, "BASE_URL"),
+		ListenAddress:     mergeSetting(*address, "SERVER_ADDRESS"),
+		StorageFilePath:   mergeSetting(*filePath, "FILE_STORAGE_PATH"),
+		BoltFilePath:      mergeSetting(*boltPath, "BOLT_STORAGE_PATH"),
+		CompactMinSize:    mergeIntSetting(*compactMinSize, "FILE_COMPACT_MIN_SIZE"),
+		CompactRatio:      mergeFloatSetting(*compactRatio, "FILE_COMPACT_RATIO"),
+		FileDurability:    mergeSetting(*fileDurability, "FILE_DURABILITY"),
+		FileSyncPeriod:    mergeDurationSetting(*fileSyncPeriod, "FILE_SYNC_INTERVAL"),
+		DSN:               mergeSetting(*dsn, "DATABASE_DSN"),
+		StorageURL:        mergeSetting(*storageURL, "STORAGE_URL"),
+		CacheSize:         mergeIntSetting(*cacheSize, "CACHE_SIZE"),
+		CacheTTL:          mergeDurationSetting(*cacheTTL, "CACHE_TTL"),
+		FallbackFile:      mergeSetting(*fallbackFile, "FALLBACK_FILE_PATH"),
+		FallbackCache:     mergeIntSetting(*fallbackCache, "FALLBACK_CACHE_SIZE"),
+		FallbackRetry:     positiveDuration(mergeDurationSetting(*fallbackRetry, "FALLBACK_RETRY_INTERVAL"), "FALLBACK_RETRY_INTERVAL"),
+		IDGenerator:       mergeSetting(*idGenerator, "ID_GENERATOR"),
+		IDLength:          mergeIntSetting(*idLength, "ID_LENGTH"),
+		IDCounterFile:     mergeSetting(*idCounterFile, "ID_COUNTER_FILE"),
+		ReaperInterval:    positiveDuration(mergeDurationSetting(*reaperInterval, "REAPER_INTERVAL"), "REAPER_INTERVAL"),
+		DeletedRetain:     mergeDurationSetting(*deletedRetain, "DELETED_RETENTION"),
+		ClickIPMode:       mergeSetting(*clickIPMode, "CLICK_IP_MODE"),
+		TrustProxyHeaders: mergeBoolSetting(*trustProxy, "TRUST_PROXY_HEADERS"),
 	}
 }
 
+// StorageDSN возвращает DSN хранилища с учетом приоритета настроек: STORAGE_URL, DATABASE_DSN, bbolt, файл, память.
+// Параметры файлового хранилища из отдельных настроек переносятся в параметры DSN
+func (c Config) StorageDSN() string {
+	if c.StorageURL != "" {
+		return c.StorageURL
+	}
+	if c.DSN != "" {
+		return c.DSN
+	}
+	if c.BoltFilePath != "" {
+		return (&url.URL{Scheme: "bolt", Path: c.BoltFilePath}).String()
+	}
+	if c.StorageFilePath != "" {
+		query := url.Values{}
+		query.Set("compact_min_size", strconv.Itoa(c.CompactMinSize))
+		query.Set("compact_ratio", strconv.FormatFloat(c.CompactRatio, 'f', -1, 64))
+		query.Set("durability", c.FileDurability)
+		query.Set("sync_interval", c.FileSyncPeriod.String())
+		return (&url.URL{Scheme: "file", Path: c.StorageFilePath, RawQuery: query.Encode()}).String()
+	}
+	return "memory://"
+}
+
 func mergeSetting(flagSetting, envSettingName string) string {
 	envSetting := os.Getenv(envSettingName)
 	if envSetting == "" {
@@ -36,3 +118,59 @@ func mergeSetting(flagSetting, envSettingName string) string {
 	}
 	return envSetting
 }
+
+func mergeIntSetting(flagSetting int, envSettingName string) int {
+	envSetting := os.Getenv(envSettingName)
+	if envSetting == "" {
+		return flagSetting
+	}
+	value, err := strconv.Atoi(envSetting)
+	if err != nil {
+		log.Fatalf("Bad %s value %q: %s", envSettingName, envSetting, err)
+	}
+	return value
+}
+
+func mergeDurationSetting(flagSetting time.Duration, envSettingName string) time.Duration {
+	envSetting := os.Getenv(envSettingName)
+	if envSetting == "" {
+		return flagSetting
+	}
+	value, err := time.ParseDuration(envSetting)
+	if err != nil {
+		log.Fatalf("Bad %s value %q: %s", envSettingName, envSetting, err)
+	}
+	return value
+}
+
+// positiveDuration проверяет что интервал фоновой задачи положительный, иначе она не может быть запущена
+func positiveDuration(value time.Duration, settingName string) time.Duration {
+	if value <= 0 {
+		log.Fatalf("Bad %s value %s: interval must be positive", settingName, value)
+	}
+	return value
+}
+
+func mergeFloatSetting(flagSetting float64, envSettingName string) float64 {
+	envSetting := os.Getenv(envSettingName)
+	if envSetting == "" {
+		return flagSetting
+	}
+	value, err := strconv.ParseFloat(envSetting, 64)
+	if err != nil {
+		log.Fatalf("Bad %s value %q: %s", envSettingName, envSetting, err)
+	}
+	return value
+}
+
+func mergeBoolSetting(flagSetting bool, envSettingName string) bool {
+	envSetting := os.Getenv(envSettingName)
+	if envSetting == "" {
+		return flagSetting
+	}
+	value, err := strconv.ParseBool(envSetting)
+	if err != nil {
+		log.Fatalf("Bad %s value %q: %s", envSettingName, envSetting, err)
+	}
+	return value
+}
@@ -9,12 +9,16 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/olkonon/shortener/internal/app/analytics"
 	"github.com/olkonon/shortener/internal/app/api"
 	"github.com/olkonon/shortener/internal/app/common"
 	"github.com/olkonon/shortener/internal/app/storage"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,10 +35,12 @@ func New(config Config) *Handler {
 		log.Fatal(err)
 	}
 	return &Handler{
-		store:     config.Store,
-		baseURL:   config.BaseURL,
-		dsn:       config.DSN,
-		secretKey: buf,
+		store:             config.Store,
+		recorder:          config.Recorder,
+		baseURL:           config.BaseURL,
+		dsn:               config.DSN,
+		secretKey:         buf,
+		trustProxyHeaders: config.TrustProxyHeaders,
 	}
 }
 
@@ -42,29 +48,102 @@ type Config struct {
 	BaseURL string
 	DSN     string
 	Store   storage.Storage
+	//Recorder запись статистики переходов, если nil статистика не собирается
+	Recorder *analytics.Recorder
+	//TrustProxyHeaders IP клиента берется из X-Real-IP и X-Forwarded-For, включается только за доверенным прокси
+	TrustProxyHeaders bool
 }
 
 type Handler struct {
-	store     storage.Storage
-	dsn       string
-	secretKey []byte
-	baseURL   string
+	store             storage.Storage
+	recorder          *analytics.Recorder
+	dsn               string
+	secretKey         []byte
+	baseURL           string
+	trustProxyHeaders bool
 }
 
 func (h *Handler) GET(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	longURL, err := h.store.GetURLByID(r.Context(), vars["id"])
 	if err != nil {
-		if errors.Is(err, storage.ErrDeletedURL) {
+		if errors.Is(err, storage.ErrDeletedURL) || errors.Is(err, storage.ErrExpiredURL) ||
+			errors.Is(err, storage.ErrClicksExhausted) {
 			w.WriteHeader(http.StatusGone)
 			return
 		}
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if h.recorder != nil {
+		h.recorder.Record(analytics.Event{
+			Time:      time.Now(),
+			ShortID:   vars["id"],
+			Referrer:  r.Referer(),
+			UserAgent: r.UserAgent(),
+			ClientIP:  h.clientIP(r),
+		})
+	}
 	http.Redirect(w, r, longURL, http.StatusTemporaryRedirect)
 }
 
+func (h *Handler) UserStatsGET(w http.ResponseWriter, r *http.Request) {
+	if h.recorder == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	vars := mux.Vars(r)
+	shortID := vars["id"]
+
+	//Статистика доступна только владельцу ссылки
+	urlList, err := h.store.GetByUser(r.Context(), vars[common.MuxUserVarName])
+	if err != nil && !errors.Is(err, storage.ErrUserURLListEmpty) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	isOwner := false
+	for _, val := range urlList {
+		if val.ShortID == shortID {
+			isOwner = true
+			break
+		}
+	}
+	if !isOwner {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	stats, err := h.recorder.Stats(r.Context(), shortID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("Get click stats error:", err)
+		return
+	}
+
+	response := api.StatsResponse{
+		ShortURL: fmt.Sprintf("%s/%s", h.baseURL, shortID),
+		Total:    stats.Total,
+		Days:     make([]api.DayStatsEntry, len(stats.Days)),
+	}
+	for i, val := range stats.Days {
+		response.Days[i].Date = val.Date
+		response.Days[i].Clicks = val.Clicks
+	}
+
+	buf, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON serialization error:", err)
+		return
+	}
+
+	w.Header().Set(ContentTypeHeader, ContentTypeApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, tmpErr := w.Write(buf); tmpErr != nil {
+		log.Error(tmpErr)
+	}
+}
+
 func (h *Handler) UserGET(w http.ResponseWriter, r *http.Request) {
 	urlList, err := h.store.GetByUser(r.Context(), mux.Vars(r)[common.MuxUserVarName])
 	if errors.Is(err, storage.ErrUserURLListEmpty) {
@@ -110,7 +189,27 @@ func (h *Handler) POST(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := h.store.GenIDByURL(r.Context(), longURL, mux.Vars(r)[common.MuxUserVarName])
+	//Срок жизни и лимит переходов ссылки передаются в параметрах запроса
+	expiration, err := expirationFromQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := expiration.ExpiresTime(time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	maxClicks, err := maxClicksFromQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := h.store.GenIDByURL(r.Context(), longURL, mux.Vars(r)[common.MuxUserVarName], storage.SaveOptions{
+		ExpiresAt: expiresAt,
+		MaxClicks: maxClicks,
+	})
 	if errors.Is(err, storage.ErrDuplicateURL) {
 		w.WriteHeader(http.StatusConflict)
 		w.Write([]byte(fmt.Sprintf("%s/%s", h.baseURL, id)))
@@ -148,15 +247,27 @@ func (h *Handler) PostJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Проверка, что переданный URl корректный
-	if !common.IsValidURL(data.URL) {
+	//Проверка, что переданный URl и псевдоним корректные
+	if !data.IsValid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := data.ExpiresTime(time.Now())
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	id, err := h.store.GenIDByURL(r.Context(), data.URL, mux.Vars(r)[common.MuxUserVarName])
+	id, err := h.store.GenIDByURL(r.Context(), data.URL, mux.Vars(r)[common.MuxUserVarName], storage.SaveOptions{
+		Alias:     data.Alias,
+		ExpiresAt: expiresAt,
+		MaxClicks: data.MaxClicks,
+	})
 	if err != nil {
-		if errors.Is(err, storage.ErrDuplicateURL) {
+		if errors.Is(err, storage.ErrAliasExists) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		} else if errors.Is(err, storage.ErrDuplicateURL) {
 			successStatusCode = http.StatusConflict
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -208,6 +319,7 @@ func (h *Handler) BatchPostJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	now := time.Now()
 	batchUpdate := make([]storage.BatchSaveRequest, len(data))
 	for i, val := range data {
 		//Проверка, что переданный URl корректный
@@ -215,8 +327,15 @@ func (h *Handler) BatchPostJSON(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		expiresAt, err := val.ExpiresTime(now)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		batchUpdate[i].OriginalURL = val.OriginalURL
 		batchUpdate[i].CorrelationID = val.CorrelationID
+		batchUpdate[i].ExpiresAt = expiresAt
+		batchUpdate[i].MaxClicks = val.MaxClicks
 	}
 
 	batchResponse, err := h.store.BatchSave(r.Context(), batchUpdate, mux.Vars(r)[common.MuxUserVarName])
@@ -229,6 +348,7 @@ func (h *Handler) BatchPostJSON(w http.ResponseWriter, r *http.Request) {
 	for i, val := range batchResponse {
 		response[i].CorrelationID = val.CorrelationID
 		response[i].ShortURL = fmt.Sprintf("%s/%s", h.baseURL, val.ShortID)
+		response[i].Status = string(val.Status)
 	}
 
 	buf, err := json.Marshal(response)
@@ -275,6 +395,151 @@ func (h *Handler) BatchDeleteJSON(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func (h *Handler) BatchRestoreJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(ContentTypeHeader) != ContentTypeApplicationJSON {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]string, 0)
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON deserialization error:", err)
+		return
+	}
+
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Error("Empty request")
+		return
+	}
+
+	//В ответе только восстановленные ID, чужие и окончательно удаленные ссылки пропускаются
+	restored, err := h.store.BatchRestore(r.Context(), data, mux.Vars(r)[common.MuxUserVarName])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("Restore error:", err)
+		return
+	}
+
+	buf, err := json.Marshal(restored)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON serialization error:", err)
+		return
+	}
+
+	w.Header().Set(ContentTypeHeader, ContentTypeApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, tmpErr := w.Write(buf); tmpErr != nil {
+		log.Error(tmpErr)
+	}
+}
+
+func (h *Handler) UserURLPATCH(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(ContentTypeHeader) != ContentTypeApplicationJSON {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var data api.UpdateURLRequest
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON deserialization error:", err)
+		return
+	}
+
+	if !data.IsValid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	//Изменить адрес может только владелец ссылки, ID при этом сохраняется
+	err = h.store.UpdateURL(r.Context(), vars["id"], vars[common.MuxUserVarName], data.URL)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrDeletedURL):
+		w.WriteHeader(http.StatusGone)
+		return
+	case errors.Is(err, storage.ErrDuplicateURL):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, storage.ErrURLTooLong):
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("Update URL error:", err)
+		return
+	}
+
+	buf, err := json.Marshal(api.UserGetResponse{
+		ShortURL:    fmt.Sprintf("%s/%s", h.baseURL, vars["id"]),
+		OriginalURL: data.URL,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON serialization error:", err)
+		return
+	}
+
+	w.Header().Set(ContentTypeHeader, ContentTypeApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, tmpErr := w.Write(buf); tmpErr != nil {
+		log.Error(tmpErr)
+	}
+}
+
+func (h *Handler) UserHistoryGET(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	history, err := h.store.GetHistory(r.Context(), vars["id"], vars[common.MuxUserVarName])
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("Get URL history error:", err)
+		return
+	}
+
+	response := make([]api.HistoryEntry, len(history))
+	for i, val := range history {
+		response[i].OriginalURL = val.URL
+		response[i].ChangedAt = val.ChangedAt
+	}
+
+	buf, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("JSON serialization error:", err)
+		return
+	}
+
+	w.Header().Set(ContentTypeHeader, ContentTypeApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, tmpErr := w.Write(buf); tmpErr != nil {
+		log.Error(tmpErr)
+	}
+}
+
 func (h Handler) Ping(w http.ResponseWriter, _ *http.Request) {
 	if h.dsn == "" {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -298,3 +563,58 @@ func (h Handler) Ping(w http.ResponseWriter, _ *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// expirationFromQuery читает срок жизни ссылки из параметров запроса ttl (секунды) и expires_at (RFC3339)
+func expirationFromQuery(r *http.Request) (api.Expiration, error) {
+	var expiration api.Expiration
+	query := r.URL.Query()
+	if ttl := query.Get("ttl"); ttl != "" {
+		value, err := strconv.ParseInt(ttl, 10, 64)
+		if err != nil {
+			return expiration, err
+		}
+		expiration.TTL = value
+	}
+	if expiresAt := query.Get("expires_at"); expiresAt != "" {
+		value, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return expiration, err
+		}
+		expiration.ExpiresAt = &value
+	}
+	return expiration, nil
+}
+
+// maxClicksFromQuery читает лимит переходов по ссылке из параметра запроса max_clicks
+func maxClicksFromQuery(r *http.Request) (int, error) {
+	maxClicks := r.URL.Query().Get("max_clicks")
+	if maxClicks == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(maxClicks)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("negative max_clicks %d", value)
+	}
+	return value, nil
+}
+
+// clientIP возвращает IP адрес клиента. Заголовки прокси клиент может подделать,
+// поэтому они учитываются только если включен TrustProxyHeaders
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.trustProxyHeaders {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,11 +2,17 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"github.com/olkonon/shortener/internal/app/analytics"
 	"github.com/olkonon/shortener/internal/app/api"
 	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/metrics"
+	"github.com/olkonon/shortener/internal/app/storage"
 	"github.com/olkonon/shortener/internal/app/storage/memory"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -146,6 +152,34 @@ func TestHandler_POST_JSON(t *testing.T) {
 				body:       api.AddURLResponse{Result: "http://example.com/" + memory.MockID1},
 			},
 		},
+		{
+			name:    "Test alias #1",
+			body:    api.AddURLRequest{URL: "http://test.com/new", Alias: "q3-report"},
+			baseURL: "http://example.com",
+			want: want{
+				json:       true,
+				statusCode: http.StatusCreated,
+				body:       api.AddURLResponse{Result: "http://example.com/q3-report"},
+			},
+		},
+		{
+			name:    "Test alias taken",
+			body:    api.AddURLRequest{URL: "http://test.com/new", Alias: memory.MockID1},
+			baseURL: "http://example.com",
+			want: want{
+				json:       false,
+				statusCode: http.StatusConflict,
+			},
+		},
+		{
+			name:    "Test reserved alias",
+			body:    api.AddURLRequest{URL: "http://test.com/new", Alias: "ping"},
+			baseURL: "http://example.com",
+			want: want{
+				json:       false,
+				statusCode: http.StatusBadRequest,
+			},
+		},
 	}
 	for _, tt := range tests {
 		test := tt
@@ -238,3 +272,340 @@ func TestHandler_GET(t *testing.T) {
 		t.Run(test.name, f)
 	}
 }
+
+func TestHandler_UserStatsGET(t *testing.T) {
+	store := memory.NewMockStorage()
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+	}()
+	anonymize, err := analytics.NewIPAnonymizer(analytics.IPModeTruncate)
+	require.NoError(t, err)
+	recorder := analytics.NewRecorder(analytics.NewMemorySink(analytics.DefaultRingSize), anonymize)
+	h := New(Config{
+		BaseURL:  "http://example.com",
+		Store:    store,
+		Recorder: recorder,
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/"+memory.MockID1, nil)
+	request = mux.SetURLVars(request, map[string]string{"id": memory.MockID1, common.MuxUserVarName: common.AnonymousUser})
+	w := httptest.NewRecorder()
+	h.GET(w, request)
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+	//Close дописывает событие перехода
+	require.NoError(t, recorder.Close())
+
+	tests := []struct {
+		name       string
+		user       string
+		statusCode int
+		total      int
+	}{
+		{name: "Test owner stats", user: common.TestUser, statusCode: http.StatusOK, total: 1},
+		{name: "Test not owner stats", user: "other-user", statusCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+memory.MockID1+"/stats", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": memory.MockID1, common.MuxUserVarName: test.user})
+			w := httptest.NewRecorder()
+			h.UserStatsGET(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, test.statusCode, result.StatusCode)
+			if test.statusCode != http.StatusOK {
+				return
+			}
+			response := api.StatsResponse{}
+			err := json.NewDecoder(result.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, test.total, response.Total)
+			assert.Equal(t, "http://example.com/"+memory.MockID1, response.ShortURL)
+		})
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(WithMetrics)
+	r.Methods(http.MethodGet).Path("/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+
+	for _, id := range []string{"first", "second", "missing"} {
+		request := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		r.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	//ID ссылок не попадают в метки, запросы учитываются по шаблону маршрута
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/{id}", http.MethodGet, "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/{id}", http.MethodGet, "404")))
+}
+
+func TestHandler_BatchRestoreJSON(t *testing.T) {
+	type want struct {
+		statusCode int
+		restored   []string
+	}
+	tests := []struct {
+		name        string
+		user        string
+		contentType string
+		body        string
+		want        want
+	}{
+		{
+			name:        "Test restore own link",
+			user:        common.TestUser,
+			contentType: ContentTypeApplicationJSON,
+			body:        `["` + memory.MockID1 + `","` + memory.MockID2 + `","q3-report"]`,
+			want: want{
+				statusCode: http.StatusOK,
+				restored:   []string{memory.MockID1},
+			},
+		},
+		{
+			name:        "Test restore other user link",
+			user:        "other-user",
+			contentType: ContentTypeApplicationJSON,
+			body:        `["` + memory.MockID1 + `"]`,
+			want: want{
+				statusCode: http.StatusOK,
+				restored:   []string{},
+			},
+		},
+		{
+			name:        "Test empty list",
+			user:        common.TestUser,
+			contentType: ContentTypeApplicationJSON,
+			body:        `[]`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:        "Test bad content type",
+			user:        common.TestUser,
+			contentType: "text/plain",
+			body:        `["` + memory.MockID1 + `"]`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			store := memory.NewMockStorage()
+			defer func() {
+				err := store.Close()
+				require.NoError(t, err)
+			}()
+			store.BatchDelete(context.Background(), []string{memory.MockID1}, common.TestUser)
+			storagetest.WaitDeleted(t, store, memory.MockID1)
+			h := New(Config{
+				BaseURL: common.DefaultBaseURL,
+				Store:   store,
+			})
+
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(test.body))
+			request.Header.Set(ContentTypeHeader, test.contentType)
+			request = mux.SetURLVars(request, map[string]string{common.MuxUserVarName: test.user})
+			w := httptest.NewRecorder()
+			h.BatchRestoreJSON(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, test.want.statusCode, result.StatusCode)
+			if test.want.statusCode != http.StatusOK {
+				return
+			}
+			restored := make([]string, 0)
+			err := json.NewDecoder(result.Body).Decode(&restored)
+			require.NoError(t, err)
+			assert.Equal(t, test.want.restored, restored)
+
+			_, err = store.GetURLByID(context.Background(), memory.MockID1)
+			if len(test.want.restored) > 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, storage.ErrDeletedURL)
+			}
+		})
+	}
+}
+
+func TestHandler_UserURLPATCH(t *testing.T) {
+	type want struct {
+		statusCode int
+		response   string
+	}
+	tests := []struct {
+		name        string
+		user        string
+		id          string
+		contentType string
+		body        string
+		want        want
+	}{
+		{
+			name:        "Test update own link",
+			user:        common.TestUser,
+			id:          memory.MockID1,
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"url":"https://new.com/test"}`,
+			want: want{
+				statusCode: http.StatusOK,
+				response:   `{"short_url":"` + common.DefaultBaseURL + `/` + memory.MockID1 + `","original_url":"https://new.com/test"}`,
+			},
+		},
+		{
+			name:        "Test update other user link",
+			user:        "other-user",
+			id:          memory.MockID1,
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"url":"https://new.com/test"}`,
+			want: want{
+				statusCode: http.StatusNotFound,
+			},
+		},
+		{
+			name:        "Test update to existing URL",
+			user:        common.TestUser,
+			id:          memory.MockID1,
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"url":"http://test.com/test"}`,
+			want: want{
+				statusCode: http.StatusConflict,
+			},
+		},
+		{
+			name:        "Test update deleted link",
+			user:        common.TestUser,
+			id:          memory.MockID2,
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"url":"https://new.com/test"}`,
+			want: want{
+				statusCode: http.StatusGone,
+			},
+		},
+		{
+			name:        "Test bad URL",
+			user:        common.TestUser,
+			id:          memory.MockID1,
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"url":"new.com"}`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:        "Test bad content type",
+			user:        common.TestUser,
+			id:          memory.MockID1,
+			contentType: "text/plain",
+			body:        `{"url":"https://new.com/test"}`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			store := memory.NewMockStorage()
+			defer func() {
+				err := store.Close()
+				require.NoError(t, err)
+			}()
+			store.BatchDelete(context.Background(), []string{memory.MockID2}, common.TestUser)
+			storagetest.WaitDeleted(t, store, memory.MockID2)
+			h := New(Config{
+				BaseURL: common.DefaultBaseURL,
+				Store:   store,
+			})
+
+			request := httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+test.id, strings.NewReader(test.body))
+			request.Header.Set(ContentTypeHeader, test.contentType)
+			request = mux.SetURLVars(request, map[string]string{"id": test.id, common.MuxUserVarName: test.user})
+			w := httptest.NewRecorder()
+			h.UserURLPATCH(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, test.want.statusCode, result.StatusCode)
+			if test.want.statusCode != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.want.response, string(body))
+
+			//Прежний адрес доступен в истории ссылки
+			request = httptest.NewRequest(http.MethodGet, "/api/user/urls/"+test.id+"/history", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": test.id, common.MuxUserVarName: test.user})
+			w = httptest.NewRecorder()
+			h.UserHistoryGET(w, request)
+			historyResult := w.Result()
+			defer historyResult.Body.Close()
+
+			require.Equal(t, http.StatusOK, historyResult.StatusCode)
+			history := make([]api.HistoryEntry, 0)
+			err = json.NewDecoder(historyResult.Body).Decode(&history)
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			assert.Equal(t, "http://test.com/test?v=3", history[0].OriginalURL)
+		})
+	}
+}
+
+func TestHandler_clientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name: "Test remote address",
+			want: "192.0.2.1",
+		},
+		{
+			name:    "Test ignore untrusted proxy headers",
+			headers: map[string]string{"X-Real-IP": "10.0.0.1", "X-Forwarded-For": "10.0.0.2"},
+			want:    "192.0.2.1",
+		},
+		{
+			name:       "Test trusted X-Real-IP",
+			trustProxy: true,
+			headers:    map[string]string{"X-Real-IP": "10.0.0.1", "X-Forwarded-For": "10.0.0.2"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "Test trusted X-Forwarded-For",
+			trustProxy: true,
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.2, 10.0.0.3"},
+			want:       "10.0.0.2",
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			h := New(Config{TrustProxyHeaders: test.trustProxy})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, val := range test.headers {
+				request.Header.Set(key, val)
+			}
+			assert.Equal(t, test.want, h.clientIP(request))
+		})
+	}
+}
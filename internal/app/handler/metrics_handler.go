@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/olkonon/shortener/internal/app/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithMetrics учитывает запросы и время их обработки по шаблону маршрута, чтобы ID ссылок не попадали в метки
+func WithMetrics(h http.Handler) http.Handler {
+	metricsFn := func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		wl := NewResponseWriterWithLog(w)
+		h.ServeHTTP(&wl, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		status := wl.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{route, r.Method, strconv.Itoa(status)}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(startTime).Seconds())
+	}
+	return http.HandlerFunc(metricsFn)
+}
@@ -0,0 +1,111 @@
+package idgen
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCounterAlphabet перемешанный алфавит base62, скрывает последовательность номеров ссылок
+const DefaultCounterAlphabet = "k3XbQ9mTzR7vLc1NwHs5YgJ0aEpF8uKd2MxVhB6tCiZ4qGyWnUeOfrDjSlIoAP"
+
+// counterReserve количество номеров, которое резервируется в файле счетчика одной записью
+const counterReserve = 1000
+
+func NewCounter(alphabet string, start uint64) *Counter {
+	c := &Counter{alphabet: alphabet}
+	c.next.Store(start)
+	return c
+}
+
+// OpenCounter создает счетчик, который хранит в файле path верхнюю границу выданных номеров.
+// Номера резервируются блоками до выдачи, после перезапуска счетчик продолжается с сохраненной границы
+func OpenCounter(alphabet string, path string) (*Counter, error) {
+	var start uint64
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		start, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad ID counter file %s: %w", path, err)
+		}
+	}
+	c := NewCounter(alphabet, start)
+	c.path = path
+	c.reserved = start
+	return c, nil
+}
+
+// Counter генератор ID из монотонного счетчика, закодированного перемешанным алфавитом
+type Counter struct {
+	alphabet string
+	next     atomic.Uint64
+
+	//path файл границы выданных номеров, пустой у счетчика без сохранения
+	path string
+	//lock защищает reserved и запись файла
+	lock     sync.Mutex
+	reserved uint64
+}
+
+func (c *Counter) Candidate(_ string, attempt int) string {
+	//При коллизии счетчик продвигается скачками, чтобы за несколько попыток обойти занятый диапазон
+	n := c.next.Add(uint64(1) << attempt)
+	if err := c.reserve(n); err != nil {
+		log.Error("ID counter save error: ", err)
+		//Пустой ID не проходит проверку в Generate, несохраненный номер не выдается
+		return ""
+	}
+	return c.encode(n)
+}
+
+func (c *Counter) encode(n uint64) string {
+	base := uint64(len(c.alphabet))
+	buf := make([]byte, 0, 11)
+	for n > 0 {
+		buf = append(buf, c.alphabet[n%base])
+		n /= base
+	}
+	return string(buf)
+}
+
+// reserve сохраняет в файл границу не меньше n, чтобы номер n не был выдан повторно после перезапуска
+func (c *Counter) reserve(n uint64) error {
+	if c.path == "" {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if n <= c.reserved {
+		return nil
+	}
+	reserved := n + counterReserve
+	tmpPath := c.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(strconv.FormatUint(reserved, 10)); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, c.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	c.reserved = reserved
+	return nil
+}
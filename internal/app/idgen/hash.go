@@ -0,0 +1,33 @@
+package idgen
+
+import "fmt"
+
+const (
+	// saltedAttempts количество попыток хэширования с солью до перехода на случайный ID
+	saltedAttempts = 4
+	// hashIDLength длина случайного ID, совпадает с длиной хэшированного ID
+	hashIDLength = 10
+)
+
+// HashFunc функция хэширования URL в ID сокращенной ссылки
+type HashFunc func(data string) string
+
+func NewHash(hash HashFunc) *Hash {
+	return &Hash{hash: hash}
+}
+
+// Hash генератор ID из хэша URL, при коллизии хэширует URL с солью, затем переходит на случайный ID
+type Hash struct {
+	hash HashFunc
+}
+
+func (h *Hash) Candidate(url string, attempt int) string {
+	switch {
+	case attempt == 0:
+		return h.hash(url)
+	case attempt < saltedAttempts:
+		return h.hash(fmt.Sprintf("%s#%d", url, attempt))
+	default:
+		return RandomString(hashIDLength)
+	}
+}
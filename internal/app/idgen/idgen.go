@@ -0,0 +1,62 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/common"
+	"strings"
+)
+
+// ErrGenerateID говорит о том что не удалось подобрать свободный ID
+var ErrGenerateID = errors.New("can't generate new ID")
+
+// MaxAttempts максимальное количество попыток подбора свободного ID
+const MaxAttempts = 16
+
+// Имена стратегий генерации ID для конфигурации
+const (
+	HashGenerator    = "hash"
+	RandomGenerator  = "random"
+	CounterGenerator = "counter"
+	ULIDGenerator    = "ulid"
+)
+
+// IDGenerator генерирует кандидата в ID сокращенной ссылки для url,
+// attempt - номер попытки, увеличивается при каждой коллизии
+type IDGenerator interface {
+	Candidate(url string, attempt int) string
+}
+
+// Generate подбирает свободный ID для url, isFree проверяет что ID еще не занят
+func Generate(gen IDGenerator, url string, isFree func(id string) (bool, error)) (string, error) {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		id := gen.Candidate(url, attempt)
+		//ID не должен совпадать со служебными путями сервиса, префикс резервного хранилища допустим
+		if !common.IsValidAlias(strings.TrimPrefix(id, FallbackPrefix)) {
+			continue
+		}
+		free, err := isFree(id)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return id, nil
+		}
+	}
+	return "", ErrGenerateID
+}
+
+// New создает генератор ID по имени стратегии, length - длина ID для случайной стратегии
+func New(name string, length int) (IDGenerator, error) {
+	switch name {
+	case HashGenerator, "":
+		return NewHash(common.GenHashedString), nil
+	case RandomGenerator:
+		return NewRandom(length)
+	case CounterGenerator:
+		return NewCounter(DefaultCounterAlphabet, 0), nil
+	case ULIDGenerator:
+		return NewULID(), nil
+	}
+	return nil, fmt.Errorf("unknown ID generator %q", name)
+}
@@ -0,0 +1,166 @@
+package idgen
+
+import (
+	"errors"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	constHash := func(string) string { return "collision" }
+
+	tests := []struct {
+		name    string
+		gen     IDGenerator
+		taken   map[string]bool
+		want    string
+		wantLen int
+	}{
+		{
+			name:  "Test free hash",
+			gen:   NewHash(common.GenHashedString),
+			taken: map[string]bool{},
+			want:  common.GenHashedString("https://test.com"),
+		},
+		{
+			name:  "Test salted hash on collision",
+			gen:   NewHash(common.GenHashedString),
+			taken: map[string]bool{common.GenHashedString("https://test.com"): true},
+			want:  common.GenHashedString("https://test.com#1"),
+		},
+		{
+			name:    "Test random fallback",
+			gen:     NewHash(constHash),
+			taken:   map[string]bool{"collision": true},
+			wantLen: hashIDLength,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Generate(test.gen, "https://test.com", func(id string) (bool, error) {
+				return !test.taken[id], nil
+			})
+			require.NoError(t, err)
+			if test.want != "" {
+				assert.Equal(t, test.want, got)
+			}
+			if test.wantLen != 0 {
+				assert.Len(t, got, test.wantLen)
+				assert.NotEqual(t, "collision", got)
+			}
+		})
+	}
+}
+
+func TestGenerate_Error(t *testing.T) {
+	allTaken := func(string) (bool, error) { return false, nil }
+	_, err := Generate(NewHash(common.GenHashedString), "https://test.com", allTaken)
+	assert.ErrorIs(t, err, ErrGenerateID)
+
+	checkErr := errors.New("check error")
+	_, err = Generate(NewHash(common.GenHashedString), "https://test.com", func(string) (bool, error) {
+		return false, checkErr
+	})
+	assert.ErrorIs(t, err, checkErr)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		gen     string
+		length  int
+		wantLen int
+		wantErr bool
+	}{
+		{name: "Test hash", gen: HashGenerator, wantLen: hashIDLength},
+		{name: "Test default", gen: "", wantLen: hashIDLength},
+		{name: "Test random", gen: RandomGenerator, length: 12, wantLen: 12},
+		{name: "Test random default length", gen: RandomGenerator, wantLen: DefaultRandomLength},
+		{name: "Test random bad length", gen: RandomGenerator, length: 2, wantErr: true},
+		{name: "Test ULID", gen: ULIDGenerator, wantLen: ulidLength},
+		{name: "Test unknown", gen: "uuid", wantErr: true},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			gen, err := New(test.gen, test.length)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, gen.Candidate("https://test.com", 0), test.wantLen)
+		})
+	}
+}
+
+func TestCounter_Candidate(t *testing.T) {
+	gen := NewCounter(DefaultCounterAlphabet, 0)
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := gen.Candidate("https://test.com", 0)
+		require.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+
+	//После перезапуска счетчик обходит занятый диапазон
+	restarted := NewCounter(DefaultCounterAlphabet, 0)
+	id, err := Generate(restarted, "https://test.com", func(id string) (bool, error) {
+		return !seen[id], nil
+	})
+	require.NoError(t, err)
+	assert.False(t, seen[id])
+}
+
+func TestOpenCounter(t *testing.T) {
+	filename := "5D4C3B2A-1F0E-4D9C-8B7A-6F5E4D3C2B1A"
+	defer os.Remove(filename)
+
+	gen, err := OpenCounter(DefaultCounterAlphabet, filename)
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for i := 0; i < 2*counterReserve; i++ {
+		id := gen.Candidate("https://test.com", 0)
+		require.NotEmpty(t, id)
+		seen[id] = true
+	}
+
+	//После перезапуска счетчик продолжается с сохраненной границы без перебора выданных ID
+	restarted, err := OpenCounter(DefaultCounterAlphabet, filename)
+	require.NoError(t, err)
+	for i := 0; i < counterReserve; i++ {
+		id := restarted.Candidate("https://test.com", 0)
+		require.False(t, seen[id], "duplicate ID %s", id)
+	}
+
+	err = os.WriteFile(filename, []byte("q3-report"), 0600)
+	require.NoError(t, err)
+	_, err = OpenCounter(DefaultCounterAlphabet, filename)
+	assert.Error(t, err)
+}
+
+func TestULID_Candidate(t *testing.T) {
+	gen := NewULID()
+	first := gen.Candidate("https://test.com", 0)
+	time.Sleep(2 * time.Millisecond)
+	second := gen.Candidate("https://test.com", 0)
+	//ID упорядочены по времени создания
+	assert.Less(t, first, second)
+}
+
+func TestPrefixed_Candidate(t *testing.T) {
+	gen := NewPrefixed(FallbackPrefix, NewHash(common.GenHashedString))
+	candidate := gen.Candidate("https://test.com", 0)
+	assert.Equal(t, FallbackPrefix+common.GenHashedString("https://test.com"), candidate)
+	//Символ префикса недопустим в псевдонимах
+	assert.False(t, common.IsValidAlias(candidate))
+
+	id, err := Generate(gen, "https://test.com", func(string) (bool, error) { return true, nil })
+	require.NoError(t, err)
+	assert.Equal(t, candidate, id)
+}
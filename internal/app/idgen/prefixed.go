@@ -0,0 +1,20 @@
+package idgen
+
+// FallbackPrefix префикс ID резервного хранилища. Символ не входит в алфавит псевдонимов
+// и других генераторов, поэтому такие ID не совпадают с ID основного хранилища
+const FallbackPrefix = "~"
+
+// NewPrefixed создает генератор, который добавляет prefix к кандидатам gen
+func NewPrefixed(prefix string, gen IDGenerator) *Prefixed {
+	return &Prefixed{prefix: prefix, gen: gen}
+}
+
+// Prefixed генератор ID с постоянным префиксом
+type Prefixed struct {
+	prefix string
+	gen    IDGenerator
+}
+
+func (p *Prefixed) Candidate(url string, attempt int) string {
+	return p.prefix + p.gen.Candidate(url, attempt)
+}
@@ -0,0 +1,47 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/common"
+	"math/big"
+)
+
+// DefaultRandomLength длина случайного ID по умолчанию
+const DefaultRandomLength = 8
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func NewRandom(length int) (*Random, error) {
+	if length == 0 {
+		length = DefaultRandomLength
+	}
+	if length < 4 || length > common.MaxAliasLength {
+		return nil, fmt.Errorf("random ID length must be from 4 to %d", common.MaxAliasLength)
+	}
+	return &Random{length: length}, nil
+}
+
+// Random генератор случайных ID из алфавита base62 заданной длины
+type Random struct {
+	length int
+}
+
+func (r *Random) Candidate(_ string, _ int) string {
+	return RandomString(r.length)
+}
+
+// RandomString возвращает криптографически случайную строку из алфавита base62
+func RandomString(length int) string {
+	buf := make([]byte, length)
+	maxIndex := big.NewInt(int64(len(base62Alphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, maxIndex)
+		if err != nil {
+			//Системный источник случайных чисел недоступен, продолжать работу нельзя
+			panic(err)
+		}
+		buf[i] = base62Alphabet[n.Int64()]
+	}
+	return string(buf)
+}
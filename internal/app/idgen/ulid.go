@@ -0,0 +1,43 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
+	"time"
+)
+
+const (
+	// ulidLength длина ID в формате ULID
+	ulidLength     = 26
+	crockfordAlpha = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+func NewULID() *ULID {
+	return &ULID{}
+}
+
+// ULID генератор ID в формате ULID: 48 бит времени в мс и 80 случайных бит,
+// ID упорядочены по времени создания
+type ULID struct{}
+
+func (u *ULID) Candidate(_ string, _ int) string {
+	var buf [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(buf[:6], ts[2:])
+	if _, err := rand.Read(buf[6:]); err != nil {
+		//Системный источник случайных чисел недоступен, продолжать работу нельзя
+		panic(err)
+	}
+
+	n := new(big.Int).SetBytes(buf[:])
+	base := big.NewInt(int64(len(crockfordAlpha)))
+	mod := new(big.Int)
+	result := make([]byte, ulidLength)
+	for i := ulidLength - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		result[i] = crockfordAlpha[mod.Int64()]
+	}
+	return string(result)
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/memory"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// queueStore хранилище с очередью удаления фиксированной длины
+type queueStore struct {
+	*memory.InMemory
+}
+
+func (qs *queueStore) DeleteQueueLen() int {
+	return 7
+}
+
+func TestStorage(t *testing.T) {
+	store := NewStorage(memory.NewInMemory(idgen.NewHash(common.GenHashedString)))
+	defer store.Close()
+
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	got, err := store.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+
+	//Штатные ответы хранилища не считаются ошибками
+	_, err = store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+	_, err = store.GetURLByID(context.Background(), "q3-report")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.GenIDByURL(context.Background(), strings.Repeat("a", storage.MaxURLLength+1), common.TestUser, storage.SaveOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLTooLong)
+	assert.Equal(t, float64(0), testutil.ToFloat64(StorageErrors.WithLabelValues("GenIDByURL")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(StorageErrors.WithLabelValues("GetURLByID")))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(StorageDuration, "shortener_storage_operation_duration_seconds"))
+}
+
+// plainStore хранилище без поддержки окончательного удаления
+type plainStore struct {
+	storage.Storage
+}
+
+func TestStorage_PurgeDeleted(t *testing.T) {
+	store := NewStorage(memory.NewInMemory(idgen.NewHash(common.GenHashedString)))
+	defer store.Close()
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	store.BatchDelete(context.Background(), []string{id}, common.TestUser)
+	storagetest.WaitDeleted(t, store, id)
+
+	purged, err := store.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, purged)
+
+	plain := NewStorage(&plainStore{Storage: memory.NewInMemory(idgen.NewHash(common.GenHashedString))})
+	defer plain.Close()
+	_, err = plain.PurgeDeleted(context.Background(), time.Now())
+	assert.Error(t, err)
+}
+
+func TestRegisterStorageGauges(t *testing.T) {
+	err := RegisterStorageGauges(memory.NewInMemory(idgen.NewHash(common.GenHashedString)))
+	require.NoError(t, err)
+
+	err = RegisterStorageGauges(&queueStore{InMemory: memory.NewInMemory(idgen.NewHash(common.GenHashedString))})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "shortener_storage_delete_queue_depth 7")
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
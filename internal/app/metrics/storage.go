@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+// NewStorage оборачивает store сбором времени выполнения и ошибок по методам
+func NewStorage(store storage.Storage) *Storage {
+	return &Storage{store: store}
+}
+
+// Storage декоратор storage.Storage с метриками
+type Storage struct {
+	store storage.Storage
+}
+
+// DeleteQueue хранилище с очередью асинхронного удаления
+type DeleteQueue interface {
+	//DeleteQueueLen возвращает количество запросов на удаление в очереди
+	DeleteQueueLen() int
+}
+
+// RegisterStorageGauges регистрирует метрики состояния хранилища, если оно их поддерживает
+func RegisterStorageGauges(store storage.Storage) error {
+	if queue, ok := store.(DeleteQueue); ok {
+		return RegisterGauge("storage", "delete_queue_depth", "Pending delete requests in storage queue.", func() float64 {
+			return float64(queue.DeleteQueueLen())
+		})
+	}
+	return nil
+}
+
+// observe учитывает время выполнения и ошибку метода
+func observe(method string, start time.Time, err error) {
+	StorageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil && !storage.IsExpected(err) {
+		StorageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *Storage) GenIDByURL(ctx context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	start := time.Now()
+	id, err := s.store.GenIDByURL(ctx, url, user, opts)
+	observe("GenIDByURL", start, err)
+	return id, err
+}
+
+func (s *Storage) GetURLByID(ctx context.Context, id string) (string, error) {
+	start := time.Now()
+	url, err := s.store.GetURLByID(ctx, id)
+	observe("GetURLByID", start, err)
+	return url, err
+}
+
+func (s *Storage) GetLink(ctx context.Context, id string) (storage.Link, error) {
+	start := time.Now()
+	link, err := s.store.GetLink(ctx, id)
+	observe("GetLink", start, err)
+	return link, err
+}
+
+func (s *Storage) GetByUser(ctx context.Context, user string) ([]storage.UserRecord, error) {
+	start := time.Now()
+	result, err := s.store.GetByUser(ctx, user)
+	observe("GetByUser", start, err)
+	return result, err
+}
+
+func (s *Storage) BatchSave(ctx context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	start := time.Now()
+	result, err := s.store.BatchSave(ctx, data, user)
+	observe("BatchSave", start, err)
+	return result, err
+}
+
+func (s *Storage) BatchDelete(ctx context.Context, data []string, user string) {
+	//Учитывается только постановка в очередь, само удаление асинхронное
+	start := time.Now()
+	s.store.BatchDelete(ctx, data, user)
+	observe("BatchDelete", start, nil)
+}
+
+func (s *Storage) UpdateURL(ctx context.Context, id string, user string, url string) error {
+	start := time.Now()
+	err := s.store.UpdateURL(ctx, id, user, url)
+	observe("UpdateURL", start, err)
+	return err
+}
+
+func (s *Storage) GetHistory(ctx context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	start := time.Now()
+	result, err := s.store.GetHistory(ctx, id, user)
+	observe("GetHistory", start, err)
+	return result, err
+}
+
+func (s *Storage) BatchRestore(ctx context.Context, data []string, user string) ([]string, error) {
+	start := time.Now()
+	result, err := s.store.BatchRestore(ctx, data, user)
+	observe("BatchRestore", start, err)
+	return result, err
+}
+
+func (s *Storage) GetExpired(ctx context.Context, now time.Time) (map[string][]string, error) {
+	start := time.Now()
+	result, err := s.store.GetExpired(ctx, now)
+	observe("GetExpired", start, err)
+	return result, err
+}
+
+func (s *Storage) Import(ctx context.Context, data []storage.Record) (int, error) {
+	start := time.Now()
+	imported, err := storage.ImportWrapped(ctx, s.store, data)
+	observe("Import", start, err)
+	return imported, err
+}
+
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	start := time.Now()
+	purged, err := storage.PurgeWrapped(ctx, s.store, before)
+	observe("PurgeDeleted", start, err)
+	return purged, err
+}
+
+func (s *Storage) Close() error {
+	return s.store.Close()
+}
@@ -4,21 +4,28 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/olkonon/shortener/internal/app/handler"
+	"github.com/olkonon/shortener/internal/app/metrics"
 	"net/http"
 )
 
 func New(h *handler.Handler) *mux.Router {
 	r := mux.NewRouter()
 	r.Use(handler.WithLog)
+	r.Use(handler.WithMetrics)
 	r.Use(handler.WithGzip)
 	r.Use(handlers.CompressHandler)
 	r.Use(h.WithAuth)
 	r.Methods(http.MethodPost).Path("/").Handler(h.AnonymousAuthHandler(h.POST))
 	r.Methods(http.MethodGet).Path("/ping").HandlerFunc(h.Ping)
+	r.Methods(http.MethodGet).Path("/metrics").Handler(metrics.Handler())
 	r.Methods(http.MethodGet).Path("/{id}").HandlerFunc(h.GET)
 	r.Methods(http.MethodPost).Path("/api/shorten/batch").Handler(h.AnonymousAuthHandler(h.BatchPostJSON))
 	r.Methods(http.MethodPost).Path("/api/shorten").Handler(h.AnonymousAuthHandler(h.PostJSON))
 	r.Methods(http.MethodGet).Path("/api/user/urls").Handler(h.RequireAuthHandler(h.UserGET))
+	r.Methods(http.MethodGet).Path("/api/user/urls/{id}/stats").Handler(h.RequireAuthHandler(h.UserStatsGET))
 	r.Methods(http.MethodDelete).Path("/api/user/urls").Handler(h.RequireAuthHandler(h.BatchDeleteJSON))
+	r.Methods(http.MethodPatch).Path("/api/user/urls/{id}").Handler(h.RequireAuthHandler(h.UserURLPATCH))
+	r.Methods(http.MethodGet).Path("/api/user/urls/{id}/history").Handler(h.RequireAuthHandler(h.UserHistoryGET))
+	r.Methods(http.MethodPost).Path("/api/user/urls/restore").Handler(h.RequireAuthHandler(h.BatchRestoreJSON))
 	return r
 }
@@ -0,0 +1,9 @@
+// Package all подключает все встроенные хранилища к реестру storage, новое хранилище добавляется сюда импортом
+package all
+
+import (
+	_ "github.com/olkonon/shortener/internal/app/storage/bolt"
+	_ "github.com/olkonon/shortener/internal/app/storage/db"
+	_ "github.com/olkonon/shortener/internal/app/storage/file"
+	_ "github.com/olkonon/shortener/internal/app/storage/memory"
+)
@@ -0,0 +1,398 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	log "github.com/sirupsen/logrus"
+	bbolt "go.etcd.io/bbolt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// urlsBucket индекс записей по ID сокращенной ссылки
+	urlsBucket = []byte("urls")
+	// usersBucket индекс по пользователю: вложенный bucket на пользователя, ключ - оригинальный URL, значение - ID
+	usersBucket = []byte("users")
+)
+
+type Record struct {
+	ID        string
+	URL       string
+	User      string
+	IsDeleted bool
+	//DeletedAt время удаления, по нему удаленная ссылка удаляется окончательно
+	DeletedAt time.Time
+	ExpiresAt time.Time
+	MaxClicks int
+	Clicks    int
+	//History прежние адреса ссылки
+	History []storage.HistoryRecord `json:",omitempty"`
+}
+
+// check проверяет что по ссылке можно перейти
+func (r Record) check(now time.Time) error {
+	if r.IsDeleted {
+		return storage.ErrDeletedURL
+	}
+	if storage.IsExpired(r.ExpiresAt, now) {
+		return storage.ErrExpiredURL
+	}
+	if r.MaxClicks > 0 && r.Clicks >= r.MaxClicks {
+		return storage.ErrClicksExhausted
+	}
+	return nil
+}
+
+// renewed возвращает удаленную или истекшую запись восстановленной с новыми параметрами, история адресов сохраняется
+func (r Record) renewed(expiresAt time.Time, maxClicks int) Record {
+	r.IsDeleted = false
+	r.DeletedAt = time.Time{}
+	r.ExpiresAt = expiresAt
+	r.MaxClicks = maxClicks
+	r.Clicks = 0
+	return r
+}
+
+func NewBoltStorage(path string, gen idgen.IDGenerator) *BoltStore {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		//Данная ошибка фатальна, так как означает что файл базы поврежден или занят другим процессом!
+		log.Fatal("Bolt open error: ", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, usersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal("Bolt init buckets error: ", err)
+	}
+	return &BoltStore{
+		db:  db,
+		gen: gen,
+	}
+}
+
+// OpenReadOnly открывает существующую базу только для чтения, изменение хранилища возвращает ошибку bbolt
+func OpenReadOnly(path string, gen idgen.IDGenerator) (*BoltStore, error) {
+	//bbolt создает отсутствующий файл и в режиме только для чтения
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, usersBucket} {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("bolt storage %s: bucket %s is missing", path, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{
+		db:  db,
+		gen: gen,
+	}, nil
+}
+
+// BoltStore хранилище на встроенной базе bbolt реализующее интерфейс Storage
+type BoltStore struct {
+	db  *bbolt.DB
+	gen idgen.IDGenerator
+	//deleteWG ожидание асинхронных удалений при закрытии
+	deleteWG sync.WaitGroup
+}
+
+func (bs *BoltStore) GenIDByURL(_ context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	if err := storage.CheckURL(url); err != nil {
+		return "", err
+	}
+	var newID string
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		userBucket, err := tx.Bucket(usersBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		if existsID := userBucket.Get([]byte(url)); existsID != nil {
+			newID = string(existsID)
+			rec, err := getRecord(tx, newID)
+			if err != nil {
+				return err
+			}
+			if !storage.IsRenewable(rec.IsDeleted, rec.ExpiresAt, time.Now()) {
+				return storage.ErrDuplicateURL
+			}
+			//Ссылка выдается заново под прежним ID, запрошенный псевдоним не применяется
+			return putRecord(tx, rec.renewed(opts.ExpiresAt, opts.MaxClicks))
+		}
+
+		urls := tx.Bucket(urlsBucket)
+		newID = opts.Alias
+		if newID != "" {
+			//Псевдоним уникален среди всех пользователей
+			if urls.Get([]byte(newID)) != nil {
+				newID = ""
+				return storage.ErrAliasExists
+			}
+		} else {
+			newID, err = idgen.Generate(bs.gen, url, func(id string) (bool, error) {
+				return urls.Get([]byte(id)) == nil, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return putRecord(tx, Record{
+			ID:        newID,
+			URL:       url,
+			User:      user,
+			IsDeleted: false,
+			ExpiresAt: opts.ExpiresAt,
+			MaxClicks: opts.MaxClicks,
+		})
+	})
+	return newID, err
+}
+
+func (bs *BoltStore) BatchSave(_ context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	//Пачка проверяется целиком до изменений, чтобы сохранить атомарность
+	if err := storage.CheckBatch(data); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := make([]storage.BatchSaveResponse, len(data))
+	//Вся пачка сохраняется в одной транзакции, при ошибке изменения откатываются
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		userBucket, err := tx.Bucket(usersBucket).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+		urls := tx.Bucket(urlsBucket)
+
+		for i, val := range data {
+			result[i].CorrelationID = val.CorrelationID
+			if existsID := userBucket.Get([]byte(val.OriginalURL)); existsID != nil {
+				result[i].ShortID = string(existsID)
+				result[i].Status = storage.SaveExisted
+				rec, err := getRecord(tx, string(existsID))
+				if err != nil {
+					return err
+				}
+				if storage.IsRenewable(rec.IsDeleted, rec.ExpiresAt, now) {
+					if err = putRecord(tx, rec.renewed(val.ExpiresAt, val.MaxClicks)); err != nil {
+						return err
+					}
+					result[i].Status = storage.SaveRestored
+				}
+				continue
+			}
+
+			newID, err := idgen.Generate(bs.gen, val.OriginalURL, func(id string) (bool, error) {
+				return urls.Get([]byte(id)) == nil, nil
+			})
+			if err != nil {
+				return err
+			}
+			err = putRecord(tx, Record{
+				ID:        newID,
+				URL:       val.OriginalURL,
+				User:      user,
+				IsDeleted: false,
+				ExpiresAt: val.ExpiresAt,
+				MaxClicks: val.MaxClicks,
+			})
+			if err != nil {
+				return err
+			}
+			result[i].ShortID = newID
+			result[i].Status = storage.SaveCreated
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (bs *BoltStore) GetURLByID(_ context.Context, ID string) (string, error) {
+	var rec Record
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx, ID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if rec.MaxClicks > 0 {
+		return bs.useClick(ID)
+	}
+	if err = rec.check(time.Now()); err != nil {
+		return "", err
+	}
+	return rec.URL, nil
+}
+
+func (bs *BoltStore) GetLink(_ context.Context, ID string) (storage.Link, error) {
+	var rec Record
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx, ID)
+		return err
+	})
+	if err != nil {
+		return storage.Link{}, err
+	}
+	if rec.IsDeleted {
+		return storage.Link{}, storage.ErrDeletedURL
+	}
+	return storage.Link{OriginalURL: rec.URL, ExpiresAt: rec.ExpiresAt, MaxClicks: rec.MaxClicks}, nil
+}
+
+// useClick атомарно проверяет лимит и учитывает переход по ссылке в транзакции на запись
+func (bs *BoltStore) useClick(ID string) (string, error) {
+	var url string
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		rec, err := getRecord(tx, ID)
+		if err != nil {
+			return err
+		}
+		if err = rec.check(time.Now()); err != nil {
+			return err
+		}
+		rec.Clicks++
+		url = rec.URL
+		return putRecord(tx, rec)
+	})
+	if err != nil {
+		return "", err
+	}
+	return url, nil
+}
+
+func (bs *BoltStore) GetByUser(_ context.Context, user string) ([]storage.UserRecord, error) {
+	result := make([]storage.UserRecord, 0)
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		userBucket := tx.Bucket(usersBucket).Bucket([]byte(user))
+		if userBucket == nil {
+			return storage.ErrUserURLListEmpty
+		}
+		return userBucket.ForEach(func(_, id []byte) error {
+			rec, err := getRecord(tx, string(id))
+			if err != nil {
+				return err
+			}
+			if !rec.IsDeleted {
+				result = append(result, storage.UserRecord{
+					OriginalURL: rec.URL,
+					ShortID:     rec.ID,
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, storage.ErrUserURLListEmpty
+	}
+	return result, nil
+}
+
+func (bs *BoltStore) BatchDelete(_ context.Context, data []string, user string) {
+	bs.deleteWG.Add(1)
+	go func() {
+		//Async
+		defer bs.deleteWG.Done()
+		now := time.Now()
+		err := bs.db.Update(func(tx *bbolt.Tx) error {
+			for _, shortURL := range data {
+				rec, err := getRecord(tx, shortURL)
+				if errors.Is(err, storage.ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				//Удалить можно только свою ссылку
+				if rec.User != user || rec.IsDeleted {
+					continue
+				}
+				rec.IsDeleted = true
+				rec.DeletedAt = now
+				if err = putRecord(tx, rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error("Bolt delete error: ", err)
+		}
+	}()
+}
+
+func (bs *BoltStore) GetExpired(_ context.Context, now time.Time) (map[string][]string, error) {
+	result := make(map[string][]string)
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(urlsBucket).ForEach(func(_, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			if !rec.IsDeleted && storage.IsExpired(rec.ExpiresAt, now) {
+				result[rec.User] = append(result[rec.User], rec.ID)
+			}
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (bs *BoltStore) Close() error {
+	//Ждем завершения асинхронных удалений
+	bs.deleteWG.Wait()
+	return bs.db.Close()
+}
+
+// getRecord читает запись по ID сокращенной ссылки
+func getRecord(tx *bbolt.Tx, ID string) (Record, error) {
+	var rec Record
+	data := tx.Bucket(urlsBucket).Get([]byte(ID))
+	if data == nil {
+		return rec, storage.ErrNotFound
+	}
+	err := json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// putRecord сохраняет запись в индексе по ID и в индексе пользователя
+func putRecord(tx *bbolt.Tx, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(urlsBucket).Put([]byte(rec.ID), data); err != nil {
+		return err
+	}
+	userBucket, err := tx.Bucket(usersBucket).CreateBucketIfNotExists([]byte(rec.User))
+	if err != nil {
+		return err
+	}
+	return userBucket.Put([]byte(rec.URL), []byte(rec.ID))
+}
@@ -0,0 +1,242 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+const testFilename = "5D0E6A3B-2B7F-4E55-A6C1-8B1D2C9E4F70"
+
+func TestBoltStorage_GenIDByURL(t *testing.T) {
+	store := NewBoltStorage(testFilename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+		err = os.Remove(testFilename)
+		require.NoError(t, err)
+	}()
+
+	testURL := "https://test.com"
+	testID := common.GenHashedString(testURL)
+	id, err := store.GenIDByURL(context.Background(), testURL, common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, testID, id)
+
+	tests := []struct {
+		name    string
+		url     string
+		user    string
+		alias   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "Test generate from existed URL",
+			url:     testURL,
+			user:    common.TestUser,
+			want:    testID,
+			wantErr: storage.ErrDuplicateURL,
+		},
+		{
+			name:    "Test reserve free alias",
+			url:     "https://test2.com",
+			user:    common.TestUser,
+			alias:   "q3-report",
+			want:    "q3-report",
+			wantErr: nil,
+		},
+		{
+			name:    "Test reserve alias taken by other user",
+			url:     "https://test3.com",
+			user:    "other-user",
+			alias:   "q3-report",
+			want:    "",
+			wantErr: storage.ErrAliasExists,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			got, err := store.GenIDByURL(context.Background(), test.url, test.user, storage.SaveOptions{
+				Alias: test.alias,
+			})
+			assert.ErrorIs(t, err, test.wantErr)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	got, err := store.GetURLByID(context.Background(), "q3-report")
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+	_, err = store.GetURLByID(context.Background(), "unknown")
+	assert.Error(t, err)
+}
+
+func TestBoltStorage_BatchSave(t *testing.T) {
+	//Все URL получают один ID, поэтому вторая запись пачки не сохраняется
+	store := NewBoltStorage(testFilename, constGenerator("fixed"))
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+		err = os.Remove(testFilename)
+		require.NoError(t, err)
+	}()
+
+	_, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+		{CorrelationID: "2", OriginalURL: "https://test2.com"},
+	}, common.TestUser)
+	assert.ErrorIs(t, err, idgen.ErrGenerateID)
+
+	//Пачка сохраняется атомарно
+	_, err = store.GetURLByID(context.Background(), "fixed")
+	assert.Error(t, err)
+	_, err = store.GetByUser(context.Background(), common.TestUser)
+	assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+
+	res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.BatchSaveResponse{{CorrelationID: "1", ShortID: "fixed", Status: storage.SaveCreated}}, res)
+}
+
+func TestBoltStorage_BatchDelete(t *testing.T) {
+	store := NewBoltStorage(testFilename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := os.Remove(testFilename)
+		require.NoError(t, err)
+	}()
+
+	res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+		{CorrelationID: "2", OriginalURL: "https://test2.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+
+	//Чужую ссылку удалить нельзя
+	store.BatchDelete(context.Background(), []string{res[0].ShortID}, "other-user")
+	store.BatchDelete(context.Background(), []string{res[1].ShortID}, common.TestUser)
+	//Close дожидается асинхронного удаления
+	err = store.Close()
+	require.NoError(t, err)
+
+	store = NewBoltStorage(testFilename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+	}()
+	got, err := store.GetURLByID(context.Background(), res[0].ShortID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	_, err = store.GetURLByID(context.Background(), res[1].ShortID)
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+
+	list, err := store.GetByUser(context.Background(), common.TestUser)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test.com", ShortID: res[0].ShortID}}, list)
+}
+
+func TestBoltStorage_Limits(t *testing.T) {
+	store := NewBoltStorage(testFilename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+		err = os.Remove(testFilename)
+		require.NoError(t, err)
+	}()
+
+	now := time.Now()
+	oneTimeID, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 1,
+	})
+	require.NoError(t, err)
+	expiredID, err := store.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{
+		ExpiresAt: now.Add(-time.Second),
+	})
+	require.NoError(t, err)
+
+	_, err = store.GetURLByID(context.Background(), oneTimeID)
+	require.NoError(t, err)
+	_, err = store.GetURLByID(context.Background(), oneTimeID)
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+
+	_, err = store.GetURLByID(context.Background(), expiredID)
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	expired, err := store.GetExpired(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{common.TestUser: {expiredID}}, expired)
+
+	storage.ReapExpired(context.Background(), store, now)
+	assert.Eventually(t, func() bool {
+		_, err := store.GetURLByID(context.Background(), expiredID)
+		return errors.Is(err, storage.ErrDeletedURL)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// constGenerator генератор, всегда возвращающий один ID
+type constGenerator string
+
+func (c constGenerator) Candidate(string, int) string {
+	return string(c)
+}
+
+func TestBoltStorage_Conformance(t *testing.T) {
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		store := NewBoltStorage(testFilename, gen)
+		t.Cleanup(func() {
+			err := store.Close()
+			require.NoError(t, err)
+			err = os.Remove(testFilename)
+			require.NoError(t, err)
+		})
+		return store
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		return newGenStore(t, idgen.NewHash(common.GenHashedString))
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunTransfer(t, newStore)
+	storagetest.RunPurge(t, newStore)
+}
+
+func TestBoltStorage_OpenReadOnly(t *testing.T) {
+	filename := "6E1F7B4C-3C8A-4F66-B7D2-9C2E3DAF5A81"
+	_, err := storage.Open("bolt:"+filename, storage.Options{Generator: idgen.NewHash(common.GenHashedString), ReadOnly: true})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	//Отсутствующая база не создается
+	_, err = os.Stat(filename)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	store := NewBoltStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer os.Remove(filename)
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	err = store.Close()
+	require.NoError(t, err)
+
+	readOnly, err := storage.Open("bolt:"+filename, storage.Options{Generator: idgen.NewHash(common.GenHashedString), ReadOnly: true})
+	require.NoError(t, err)
+	defer readOnly.Close()
+	got, err := readOnly.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	_, err = readOnly.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package bolt
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+func (bs *BoltStore) UpdateURL(_ context.Context, id string, user string, url string) error {
+	if err := storage.CheckURL(url); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bbolt.Tx) error {
+		rec, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		if rec.User != user {
+			return storage.ErrNotFound
+		}
+		if rec.IsDeleted {
+			return storage.ErrDeletedURL
+		}
+		if rec.URL == url {
+			return nil
+		}
+		userBucket := tx.Bucket(usersBucket).Bucket([]byte(user))
+		if userBucket.Get([]byte(url)) != nil {
+			return storage.ErrDuplicateURL
+		}
+
+		//Прежний адрес освобождается в индексе пользователя
+		if err = userBucket.Delete([]byte(rec.URL)); err != nil {
+			return err
+		}
+		rec.History = storage.AppendHistory(rec.History, rec.URL, time.Now())
+		rec.URL = url
+		return putRecord(tx, rec)
+	})
+}
+
+func (bs *BoltStore) GetHistory(_ context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	var rec Record
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rec.User != user {
+		return nil, storage.ErrNotFound
+	}
+	return append([]storage.HistoryRecord{}, rec.History...), nil
+}
@@ -0,0 +1,60 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+func (bs *BoltStore) PurgeDeleted(_ context.Context, before time.Time) ([]string, error) {
+	var purged []string
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		purged = make([]string, 0)
+		now := time.Now()
+		var toPurge, toStamp []Record
+		err := tx.Bucket(urlsBucket).ForEach(func(_, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			switch {
+			case !rec.IsDeleted:
+			case rec.DeletedAt.IsZero():
+				//Ссылки, удаленные до учета времени удаления, хранятся срок хранения начиная с этого момента
+				rec.DeletedAt = now
+				toStamp = append(toStamp, rec)
+			case rec.DeletedAt.Before(before):
+				toPurge = append(toPurge, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		//Bucket нельзя изменять во время ForEach
+		for _, rec := range toStamp {
+			if err = putRecord(tx, rec); err != nil {
+				return err
+			}
+		}
+		for _, rec := range toPurge {
+			if err = tx.Bucket(urlsBucket).Delete([]byte(rec.ID)); err != nil {
+				return err
+			}
+			userBucket := tx.Bucket(usersBucket).Bucket([]byte(rec.User))
+			if userBucket != nil && string(userBucket.Get([]byte(rec.URL))) == rec.ID {
+				if err = userBucket.Delete([]byte(rec.URL)); err != nil {
+					return err
+				}
+			}
+			purged = append(purged, rec.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,26 @@
+package bolt
+
+import (
+	"github.com/olkonon/shortener/internal/app/storage"
+	"net/url"
+)
+
+// Scheme схема DSN хранилища bbolt: bolt:///path/to/file.db
+const Scheme = "bolt"
+
+func init() {
+	storage.Register(Scheme, func(dsn string, opts storage.Options) (storage.Storage, error) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return nil, err
+		}
+		path, err := storage.FilePath(u)
+		if err != nil {
+			return nil, err
+		}
+		if opts.ReadOnly {
+			return OpenReadOnly(path, opts.Generator)
+		}
+		return NewBoltStorage(path, opts.Generator), nil
+	})
+}
@@ -0,0 +1,41 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/storage"
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+func (bs *BoltStore) BatchRestore(_ context.Context, data []string, user string) ([]string, error) {
+	var restored []string
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		restored = make([]string, 0, len(data))
+		for _, id := range data {
+			rec, err := getRecord(tx, id)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			//Восстановить можно только свою удаленную и еще не истекшую ссылку
+			if rec.User != user || !rec.IsDeleted || storage.IsExpired(rec.ExpiresAt, now) {
+				continue
+			}
+			rec.IsDeleted = false
+			rec.DeletedAt = time.Time{}
+			if err = putRecord(tx, rec); err != nil {
+				return err
+			}
+			restored = append(restored, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
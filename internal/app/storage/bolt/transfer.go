@@ -0,0 +1,73 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olkonon/shortener/internal/app/storage"
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+func (bs *BoltStore) Export(_ context.Context, f func(rec storage.Record) error) error {
+	return bs.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(urlsBucket).ForEach(func(_, data []byte) error {
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			return f(storage.Record{
+				ShortID:     rec.ID,
+				OriginalURL: rec.URL,
+				User:        rec.User,
+				IsDeleted:   rec.IsDeleted,
+				DeletedAt:   rec.DeletedAt,
+				ExpiresAt:   rec.ExpiresAt,
+				MaxClicks:   rec.MaxClicks,
+				Clicks:      rec.Clicks,
+				History:     rec.History,
+			})
+		})
+	})
+}
+
+func (bs *BoltStore) Import(_ context.Context, data []storage.Record) (int, error) {
+	imported := 0
+	now := time.Now()
+	//Пачка загружается в одной транзакции
+	err := bs.db.Update(func(tx *bbolt.Tx) error {
+		imported = 0
+		urls := tx.Bucket(urlsBucket)
+		users := tx.Bucket(usersBucket)
+		for _, rec := range data {
+			if urls.Get([]byte(rec.ShortID)) != nil {
+				continue
+			}
+			if userBucket := users.Bucket([]byte(rec.User)); userBucket != nil && userBucket.Get([]byte(rec.OriginalURL)) != nil {
+				continue
+			}
+			if rec.IsDeleted && rec.DeletedAt.IsZero() {
+				rec.DeletedAt = now
+			}
+			err := putRecord(tx, Record{
+				ID:        rec.ShortID,
+				URL:       rec.OriginalURL,
+				User:      rec.User,
+				IsDeleted: rec.IsDeleted,
+				DeletedAt: rec.DeletedAt,
+				ExpiresAt: rec.ExpiresAt,
+				MaxClicks: rec.MaxClicks,
+				Clicks:    rec.Clicks,
+				History:   rec.History,
+			})
+			if err != nil {
+				return err
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
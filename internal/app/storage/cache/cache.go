@@ -0,0 +1,238 @@
+// Package cache декоратор storage.Storage с LRU кэшем переходов по сокращенным ссылкам
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"sync"
+	"time"
+)
+
+// New оборачивает store кэшем GetURLByID на size записей со сроком жизни ttl
+func New(store storage.Storage, size int, ttl time.Duration) *Cached {
+	return &Cached{
+		store:   store,
+		size:    size,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]time.Time),
+	}
+}
+
+// Cached кэширует найденные и ненайденные ссылки. Ссылки с лимитом переходов не кэшируются,
+// чтобы каждый переход учитывался хранилищем
+type Cached struct {
+	store storage.Storage
+	size  int
+	ttl   time.Duration
+
+	lock  sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	//pending ID с незавершенным асинхронным удалением, до истечения срока они читаются мимо кэша
+	pending map[string]time.Time
+	//generation меняется при каждой инвалидации, результат чтения из хранилища не кэшируется если она изменилась
+	generation uint64
+}
+
+// entry запись кэша, err заполнен для кэшированного отрицательного ответа
+type entry struct {
+	id         string
+	url        string
+	err        error
+	validUntil time.Time
+}
+
+func (c *Cached) GenIDByURL(ctx context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	id, err := c.store.GenIDByURL(ctx, url, user, opts)
+	if err == nil {
+		//ID мог быть закэширован как ненайденный
+		c.invalidate(id)
+	}
+	return id, err
+}
+
+func (c *Cached) GetURLByID(ctx context.Context, id string) (string, error) {
+	now := time.Now()
+	c.lock.Lock()
+	if until, isPending := c.pending[id]; isPending {
+		if now.Before(until) {
+			c.lock.Unlock()
+			return c.store.GetURLByID(ctx, id)
+		}
+		delete(c.pending, id)
+	}
+	if elem, isExists := c.items[id]; isExists {
+		e := elem.Value.(*entry)
+		if now.Before(e.validUntil) {
+			c.order.MoveToFront(elem)
+			c.lock.Unlock()
+			return e.url, e.err
+		}
+		c.remove(elem)
+	}
+	generation := c.generation
+	c.lock.Unlock()
+
+	link, err := c.store.GetLink(ctx, id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeletedURL):
+		c.put(generation, &entry{id: id, err: err, validUntil: now.Add(c.ttl)})
+		return "", err
+	case err != nil:
+		return "", err
+	case link.MaxClicks > 0:
+		//Переход по ссылке с лимитом должен учитываться хранилищем
+		return c.store.GetURLByID(ctx, id)
+	case storage.IsExpired(link.ExpiresAt, now):
+		c.put(generation, &entry{id: id, err: storage.ErrExpiredURL, validUntil: now.Add(c.ttl)})
+		return "", storage.ErrExpiredURL
+	}
+
+	validUntil := now.Add(c.ttl)
+	if !link.ExpiresAt.IsZero() && link.ExpiresAt.Before(validUntil) {
+		validUntil = link.ExpiresAt
+	}
+	c.put(generation, &entry{id: id, url: link.OriginalURL, validUntil: validUntil})
+	return link.OriginalURL, nil
+}
+
+func (c *Cached) GetLink(ctx context.Context, id string) (storage.Link, error) {
+	return c.store.GetLink(ctx, id)
+}
+
+func (c *Cached) GetByUser(ctx context.Context, user string) ([]storage.UserRecord, error) {
+	return c.store.GetByUser(ctx, user)
+}
+
+func (c *Cached) BatchSave(ctx context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	result, err := c.store.BatchSave(ctx, data, user)
+	//Восстановленные и новые ID могли быть закэшированы как удаленные или ненайденные
+	ids := make([]string, 0, len(result))
+	for _, val := range result {
+		if val.ShortID != "" {
+			ids = append(ids, val.ShortID)
+		}
+	}
+	c.invalidate(ids...)
+	return result, err
+}
+
+func (c *Cached) BatchDelete(ctx context.Context, data []string, user string) {
+	//Удаление асинхронное, поэтому до истечения ttl ID читаются мимо кэша, чтобы не закэшировать еще не удаленную ссылку
+	c.lock.Lock()
+	now := time.Now()
+	for id, until := range c.pending {
+		if !now.Before(until) {
+			delete(c.pending, id)
+		}
+	}
+	for _, id := range data {
+		c.pending[id] = now.Add(c.ttl)
+	}
+	c.lock.Unlock()
+	c.invalidate(data...)
+
+	c.store.BatchDelete(ctx, data, user)
+}
+
+func (c *Cached) UpdateURL(ctx context.Context, id string, user string, url string) error {
+	err := c.store.UpdateURL(ctx, id, user, url)
+	if err == nil {
+		//В кэше остался прежний адрес
+		c.invalidate(id)
+	}
+	return err
+}
+
+func (c *Cached) GetHistory(ctx context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	return c.store.GetHistory(ctx, id, user)
+}
+
+func (c *Cached) BatchRestore(ctx context.Context, data []string, user string) ([]string, error) {
+	restored, err := c.store.BatchRestore(ctx, data, user)
+	//Восстановленные ID могли быть закэшированы как удаленные
+	c.lock.Lock()
+	for _, id := range restored {
+		delete(c.pending, id)
+	}
+	c.lock.Unlock()
+	c.invalidate(restored...)
+	return restored, err
+}
+
+func (c *Cached) GetExpired(ctx context.Context, now time.Time) (map[string][]string, error) {
+	return c.store.GetExpired(ctx, now)
+}
+
+func (c *Cached) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	purged, err := storage.PurgeWrapped(ctx, c.store, before)
+	if len(purged) > 0 {
+		//Очищенные ID неизвестны хранилищу и могут быть выданы снова, поэтому закэшированные удаления сбрасываются
+		c.invalidateDeleted()
+	}
+	return purged, err
+}
+
+func (c *Cached) Close() error {
+	return c.store.Close()
+}
+
+// Len возвращает количество записей в кэше
+func (c *Cached) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// invalidate удаляет ID из кэша
+func (c *Cached) invalidate(ids ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	for _, id := range ids {
+		if elem, isExists := c.items[id]; isExists {
+			c.remove(elem)
+		}
+	}
+}
+
+// invalidateDeleted удаляет из кэша ответы ErrDeletedURL
+func (c *Cached) invalidateDeleted() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	for _, elem := range c.items {
+		if errors.Is(elem.Value.(*entry).err, storage.ErrDeletedURL) {
+			c.remove(elem)
+		}
+	}
+}
+
+// put сохраняет запись, если с начала чтения из хранилища не было инвалидаций
+func (c *Cached) put(generation uint64, e *entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, isExists := c.items[e.id]; isExists {
+		c.remove(elem)
+	}
+	c.items[e.id] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove удаляет запись из кэша, вызывается под блокировкой
+func (c *Cached) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*entry).id)
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/memory"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// countingStore считает обращения к хранилищу за параметрами ссылки
+type countingStore struct {
+	*memory.InMemory
+	lookups atomic.Int32
+}
+
+func (cs *countingStore) GetLink(ctx context.Context, id string) (storage.Link, error) {
+	cs.lookups.Add(1)
+	return cs.InMemory.GetLink(ctx, id)
+}
+
+func newTestCache(t *testing.T, size int, ttl time.Duration) (*Cached, *countingStore) {
+	return newTestCacheGen(t, size, ttl, idgen.NewHash(common.GenHashedString))
+}
+
+func newTestCacheGen(t *testing.T, size int, ttl time.Duration, gen idgen.IDGenerator) (*Cached, *countingStore) {
+	inner := &countingStore{InMemory: memory.NewInMemory(gen)}
+	c := New(inner, size, ttl)
+	t.Cleanup(func() {
+		err := c.Close()
+		require.NoError(t, err)
+	})
+	return c, inner
+}
+
+func TestCached_GetURLByID(t *testing.T) {
+	c, inner := newTestCache(t, 10, time.Hour)
+	id, err := c.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		got, err := c.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+	}
+	assert.Equal(t, int32(1), inner.lookups.Load())
+
+	//Отрицательный ответ тоже кэшируется
+	for i := 0; i < 3; i++ {
+		_, err = c.GetURLByID(context.Background(), "q3-report")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+	assert.Equal(t, int32(2), inner.lookups.Load())
+
+	//Создание ссылки сбрасывает отрицательный ответ
+	_, err = c.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{Alias: "q3-report"})
+	require.NoError(t, err)
+	got, err := c.GetURLByID(context.Background(), "q3-report")
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+}
+
+func TestCached_MaxClicks(t *testing.T) {
+	c, _ := newTestCache(t, 10, time.Hour)
+	id, err := c.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{MaxClicks: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = c.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+	}
+	_, err = c.GetURLByID(context.Background(), id)
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCached_DeleteAndRestore(t *testing.T) {
+	c, _ := newTestCache(t, 10, time.Hour)
+	res, err := c.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+	id := res[0].ShortID
+
+	_, err = c.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Len())
+
+	c.BatchDelete(context.Background(), []string{id}, common.TestUser)
+	assert.Eventually(t, func() bool {
+		_, err := c.GetURLByID(context.Background(), id)
+		return errors.Is(err, storage.ErrDeletedURL)
+	}, time.Second, 10*time.Millisecond)
+
+	res, err = c.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+	require.Equal(t, storage.SaveRestored, res[0].Status)
+	got, err := c.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+}
+
+func TestCached_PurgeDeleted(t *testing.T) {
+	c, inner := newTestCache(t, 10, time.Hour)
+	id, err := c.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	//Удаление мимо кэша, чтобы ответ ErrDeletedURL был закэширован
+	inner.BatchDelete(context.Background(), []string{id}, common.TestUser)
+	storagetest.WaitDeleted(t, inner, id)
+	_, err = c.GetURLByID(context.Background(), id)
+	require.ErrorIs(t, err, storage.ErrDeletedURL)
+	require.Equal(t, 1, c.Len())
+
+	purged, err := c.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, purged)
+	assert.Equal(t, 0, c.Len())
+	_, err = c.GetURLByID(context.Background(), id)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestCached_Limits(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		c, _ := newTestCache(t, 2, time.Hour)
+		for _, url := range []string{"https://test.com", "https://test2.com", "https://test3.com"} {
+			id, err := c.GenIDByURL(context.Background(), url, common.TestUser, storage.SaveOptions{})
+			require.NoError(t, err)
+			_, err = c.GetURLByID(context.Background(), id)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, c.Len())
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c, inner := newTestCache(t, 10, 20*time.Millisecond)
+		id, err := c.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		_, err = c.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		time.Sleep(30 * time.Millisecond)
+		_, err = c.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), inner.lookups.Load())
+	})
+
+	t.Run("link expiration", func(t *testing.T) {
+		c, _ := newTestCache(t, 10, time.Hour)
+		id, err := c.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+			ExpiresAt: time.Now().Add(30 * time.Millisecond),
+		})
+		require.NoError(t, err)
+		_, err = c.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		time.Sleep(40 * time.Millisecond)
+		_, err = c.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	})
+}
+
+func TestCached_Conformance(t *testing.T) {
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		c, _ := newTestCacheGen(t, 10, time.Hour, gen)
+		return c
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		return newGenStore(t, idgen.NewHash(common.GenHashedString))
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunPurge(t, newStore)
+}
@@ -4,85 +4,222 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
-	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
 	"github.com/olkonon/shortener/internal/app/storage"
 	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 	"time"
 )
 
-const CreateTable = `CREATE TABLE IF NOT EXISTS urls (
-    	user_id varchar(36) NOT NULL,
-    	original_url varchar(256) NOT NULL,
-    	short_url varchar(10) NOT NULL,
-    	is_deleted boolean NOT NULL,
-    	PRIMARY KEY (user_id,original_url)
-)`
-const SelectURLByID = `SELECT original_url,is_deleted FROM urls WHERE short_url=$1;`
+const LockShortURL = `SELECT pg_advisory_xact_lock(hashtext($1));`
+const SelectShortURLExists = `SELECT EXISTS(SELECT 1 FROM urls WHERE short_url=$1);`
+
+// URLHash выражение ключа URL в таблице, совпадает с вычислением url_hash в миграции 0004
+const URLHash = `encode(sha256(convert_to($2::text,'UTF8')),'hex')`
+const SelectShortURLByURL = `SELECT short_url FROM urls WHERE user_id=$1 AND url_hash=` + URLHash + `;`
+
+// SelectShortURLsByURLs ID уже сохраненных пользователем URL из списка $2
+const SelectShortURLsByURLs = `SELECT original_url,short_url FROM urls WHERE user_id=$1 AND url_hash IN
+	(SELECT encode(sha256(convert_to(u,'UTF8')),'hex') FROM unnest($2::text[]) AS u);`
+const SelectURLByID = `SELECT original_url,is_deleted,expires_at,max_clicks FROM urls WHERE short_url=$1;`
+const UseClick = `UPDATE urls SET clicks=clicks+1 WHERE short_url=$1 AND clicks<max_clicks AND NOT is_deleted
+	AND (expires_at IS NULL OR expires_at>now()) RETURNING original_url;`
+const SelectExpired = `SELECT user_id,short_url FROM urls WHERE expires_at<=$1 AND NOT is_deleted;`
 const SelectURLByUser = `SELECT original_url,short_url FROM urls WHERE user_id=$1 AND NOT is_deleted;`
-const InsertToTable = `INSERT INTO urls (short_url,original_url,user_id,is_deleted) VALUES ($1,$2,$3,false)`
-const DeleteURLByID = `UPDATE urls SET is_deleted=TRUE WHERE user_id=$1 AND short_url = any($2);`
+const InsertToTable = `INSERT INTO urls (short_url,original_url,url_hash,user_id,is_deleted,expires_at,max_clicks)
+	VALUES ($1,$2,` + URLHash + `,$3,false,$4,$5)`
+
+// RenewURL выдает заново удаленную или истекшую ссылку пользователя с новыми параметрами
+const RenewURL = `UPDATE urls SET is_deleted=false,deleted_at=NULL,expires_at=$3,max_clicks=$4,clicks=0
+	WHERE short_url=$1 AND user_id=$2 AND (is_deleted OR expires_at<=now());`
+
+// UpsertToTable вставляет URL или восстанавливает удаленный или истекший, для живой записи строка не возвращается.
+// xmax=0 только у вставленной строки, у обновленной xmax содержит ID текущей транзакции
+const UpsertToTable = `INSERT INTO urls (short_url,original_url,url_hash,user_id,is_deleted,expires_at,max_clicks)
+	VALUES ($1,$2,` + URLHash + `,$3,false,$4,$5)
+	ON CONFLICT (user_id,url_hash) DO UPDATE SET is_deleted=false,deleted_at=NULL,expires_at=EXCLUDED.expires_at,
+	max_clicks=EXCLUDED.max_clicks,clicks=0 WHERE urls.is_deleted OR urls.expires_at<=now()
+	RETURNING short_url,(xmax=0) AS inserted`
+
+// NewDatabaseStore подключается к Postgres и приводит схему к последней версии
+func NewDatabaseStore(dsn string, gen idgen.IDGenerator) (*DatabaseStore, error) {
+	return openDatabaseStore(dsn, gen, true)
+}
 
-type ChanMsg struct {
-	User      string
-	ShortURLs []string
+// OpenReadOnly подключается к Postgres без миграций, все транзакции сессий открываются только для чтения
+func OpenReadOnly(dsn string, gen idgen.IDGenerator) (*DatabaseStore, error) {
+	conninfo, err := readOnlyDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return openDatabaseStore(conninfo, gen, false)
 }
 
-func NewDatabaseStore(dsn string) *DatabaseStore {
+// readOnlyDSN добавляет к DSN параметр сессии default_transaction_read_only
+func readOnlyDSN(dsn string) (string, error) {
+	conninfo := dsn
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if conninfo, err = pq.ParseURL(dsn); err != nil {
+			return "", err
+		}
+	}
+	return conninfo + " default_transaction_read_only=on", nil
+}
+
+func openDatabaseStore(dsn string, gen idgen.IDGenerator, migrate bool) (*DatabaseStore, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		//Фатальная ошибка с базой что-то явно не так
-		log.Fatal("DB connect error", err)
+		return nil, fmt.Errorf("DB connect error: %w", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err = db.PingContext(ctx); err != nil {
-		//Фатальная ошибка с базой что-то явно не так если она за 5с не ответила на пинг
-		log.Fatal("DB Ping error", err)
+		db.Close()
+		return nil, fmt.Errorf("DB ping error: %w", err)
 	}
 
-	_, err = db.Exec(CreateTable)
-	if err != nil {
-		//Фатальная ошибка с базой что-то явно не так
-		log.Fatal("DB init tables error", err)
+	if migrate {
+		//Схема приводится к последней версии, параллельные экземпляры ждут друг друга на advisory lock
+		if err = migrateUp(db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("DB migrations error: %w", err)
+		}
 	}
 
 	tmp := &DatabaseStore{
 		db:               db,
-		deletedChan:      make(chan ChanMsg, 32),
-		stopChan:         make(chan bool),
+		gen:              gen,
+		deletedChan:      make(chan ChanMsg, deleteQueueSize),
 		stopFinishedChan: make(chan bool),
 	}
 
 	go tmp.deleteWorker()
-	return tmp
+	return tmp, nil
+}
+
+func migrateUp(db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(context.Background())
+	return err
 }
 
 type DatabaseStore struct {
 	db               *sql.DB
+	gen              idgen.IDGenerator
 	deletedChan      chan ChanMsg
-	stopChan         chan bool
 	stopFinishedChan chan bool
+	//closeLock не дает BatchDelete писать в закрытую очередь удаления
+	closeLock sync.RWMutex
+	isClosed  bool
 }
 
-func (dbs *DatabaseStore) GenIDByURL(ctx context.Context, url string, user string) (string, error) {
-	newID := common.GenHashedString(url)
-	_, err := dbs.db.ExecContext(ctx, InsertToTable, newID, url, user)
-	var pgError *pq.Error
+func (dbs *DatabaseStore) GenIDByURL(ctx context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	if err := storage.CheckURL(url); err != nil {
+		return "", err
+	}
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	//Откат транзакции если Commit не прошел
+	defer tx.Rollback()
+
+	var existsID string
+	err = tx.QueryRowContext(ctx, SelectShortURLByURL, user, url).Scan(&existsID)
 	if err == nil {
-		return newID, nil
+		return dbs.renew(ctx, tx, existsID, user, opts)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	newID := opts.Alias
+	if newID != "" {
+		//Псевдоним уникален среди всех пользователей
+		isFree, err := isFreeID(ctx, tx, newID)
+		if err != nil {
+			return "", err
+		}
+		if !isFree {
+			return "", storage.ErrAliasExists
+		}
+	} else {
+		newID, err = idgen.Generate(dbs.gen, url, func(id string) (bool, error) {
+			return isFreeID(ctx, tx, id)
+		})
+		if err != nil {
+			return "", err
+		}
 	}
+
+	_, err = tx.ExecContext(ctx, InsertToTable, newID, url, user, nullTime(opts.ExpiresAt), opts.MaxClicks)
+	var pgError *pq.Error
 	if errors.As(err, &pgError) && pgError.Code == pgerrcode.UniqueViolation {
-		return newID, storage.ErrDuplicateURL
+		//Параллельный запрос успел сохранить тот же URL
+		return dbs.existsShortURL(ctx, url, user)
+	}
+	if err != nil {
+		return "", err
+	}
+	return newID, tx.Commit()
+}
+
+// renew выдает заново под прежним ID удаленную или истекшую ссылку, для живой ссылки возвращает ErrDuplicateURL.
+// Запрошенный псевдоним не применяется
+func (dbs *DatabaseStore) renew(ctx context.Context, tx *sql.Tx, existsID string, user string, opts storage.SaveOptions) (string, error) {
+	res, err := tx.ExecContext(ctx, RenewURL, existsID, user, nullTime(opts.ExpiresAt), opts.MaxClicks)
+	if err != nil {
+		return "", err
+	}
+	renewed, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if renewed == 0 {
+		return existsID, storage.ErrDuplicateURL
+	}
+	return existsID, tx.Commit()
+}
+
+// isFreeID проверяет что ID не занят, блокируя его до конца транзакции,
+// чтобы параллельные запросы не заняли один ID
+func isFreeID(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	if _, err := tx.ExecContext(ctx, LockShortURL, id); err != nil {
+		return false, err
+	}
+
+	var isExists bool
+	if err := tx.QueryRowContext(ctx, SelectShortURLExists, id).Scan(&isExists); err != nil {
+		return false, err
 	}
-	return "", err
+	return !isExists, nil
+}
+
+// existsShortURL возвращает ID уже сохраненного пользователем url вместе с ErrDuplicateURL
+func (dbs *DatabaseStore) existsShortURL(ctx context.Context, url string, user string) (string, error) {
+	var shortID string
+	if err := dbs.db.QueryRowContext(ctx, SelectShortURLByURL, user, url).Scan(&shortID); err != nil {
+		return "", err
+	}
+	return shortID, storage.ErrDuplicateURL
 }
 
 func (dbs *DatabaseStore) BatchSave(ctx context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	//Пачка проверяется целиком до изменений, чтобы сохранить атомарность
+	if err := storage.CheckBatch(data); err != nil {
+		return nil, err
+	}
 	result := make([]storage.BatchSaveResponse, len(data))
-	tx, err := dbs.db.Begin()
+	tx, err := dbs.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Error(err)
 		return result, err
@@ -90,41 +227,167 @@ func (dbs *DatabaseStore) BatchSave(ctx context.Context, data []storage.BatchSav
 	//Откат транзакции если Commit не прошел
 	defer tx.Rollback()
 
-	insertStmt, err := tx.PrepareContext(ctx, InsertToTable)
+	upsertStmt, err := tx.PrepareContext(ctx, UpsertToTable)
 	if err != nil {
 		log.Error(err)
 		return result, err
 	}
+	defer upsertStmt.Close()
 
-	txStmt := tx.StmtContext(ctx, insertStmt)
-
+	//ID генерируются только для новых URL, уже сохраненные выбираются одним запросом
+	existsIDs, err := selectShortURLs(ctx, tx, data, user)
+	if err != nil {
+		return result, err
+	}
+	batchIDByURL := make(map[string]string)
 	for i, val := range data {
-		newID := common.GenHashedString(val.OriginalURL)
-		if _, err = txStmt.ExecContext(ctx, newID, val.OriginalURL, user); err != nil {
-			return result, err
+		result[i].CorrelationID = val.CorrelationID
+		if existsID, isExists := batchIDByURL[val.OriginalURL]; isExists {
+			result[i].ShortID = existsID
+			result[i].Status = storage.SaveExisted
+			continue
+		}
+		newID, isSaved := existsIDs[val.OriginalURL]
+		if !isSaved {
+			newID, err = idgen.Generate(dbs.gen, val.OriginalURL, func(id string) (bool, error) {
+				return isFreeID(ctx, tx, id)
+			})
+			if err != nil {
+				return result, err
+			}
 		}
 
-		result[i].CorrelationID = val.CorrelationID
-		result[i].ShortID = newID
+		var shortID string
+		var inserted bool
+		err = upsertStmt.QueryRowContext(ctx, newID, val.OriginalURL, user, nullTime(val.ExpiresAt), val.MaxClicks).Scan(&shortID, &inserted)
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && isSaved:
+			//URL уже сохранен пользователем и действует
+			shortID = newID
+			result[i].Status = storage.SaveExisted
+		case errors.Is(err, sql.ErrNoRows):
+			//URL успел сохранить параллельный запрос
+			if err = tx.QueryRowContext(ctx, SelectShortURLByURL, user, val.OriginalURL).Scan(&shortID); err != nil {
+				return result, err
+			}
+			result[i].Status = storage.SaveExisted
+		case err != nil:
+			return result, err
+		case inserted:
+			result[i].Status = storage.SaveCreated
+		default:
+			result[i].Status = storage.SaveRestored
+		}
+		result[i].ShortID = shortID
+		batchIDByURL[val.OriginalURL] = shortID
 	}
 
 	return result, tx.Commit()
 }
 
+// selectShortURLs возвращает ID уже сохраненных пользователем URL пачки
+func selectShortURLs(ctx context.Context, tx *sql.Tx, data []storage.BatchSaveRequest, user string) (map[string]string, error) {
+	urls := make([]string, 0, len(data))
+	for _, val := range data {
+		urls = append(urls, val.OriginalURL)
+	}
+	rows, err := tx.QueryContext(ctx, SelectShortURLsByURLs, user, pq.Array(urls))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]string)
+	for rows.Next() {
+		var url, shortID string
+		if err = rows.Scan(&url, &shortID); err != nil {
+			return nil, err
+		}
+		result[url] = shortID
+	}
+	return result, rows.Err()
+}
+
 func (dbs *DatabaseStore) GetURLByID(ctx context.Context, ID string) (string, error) {
 	rowURL := dbs.db.QueryRowContext(ctx, SelectURLByID, ID)
 	var url string
 	var isDeleted bool
-	err := rowURL.Scan(&url, &isDeleted)
+	var expiresAt sql.NullTime
+	var maxClicks int
+	err := rowURL.Scan(&url, &isDeleted, &expiresAt, &maxClicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrNotFound
+	}
 	if err != nil {
 		return "", err
 	}
 	if isDeleted {
 		return "", storage.ErrDeletedURL
 	}
+	if storage.IsExpired(expiresAt.Time, time.Now()) {
+		return "", storage.ErrExpiredURL
+	}
+	if maxClicks > 0 {
+		return dbs.useClick(ctx, ID)
+	}
 	return url, nil
 }
 
+func (dbs *DatabaseStore) GetLink(ctx context.Context, ID string) (storage.Link, error) {
+	var link storage.Link
+	var isDeleted bool
+	var expiresAt sql.NullTime
+	err := dbs.db.QueryRowContext(ctx, SelectURLByID, ID).Scan(&link.OriginalURL, &isDeleted, &expiresAt, &link.MaxClicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Link{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Link{}, err
+	}
+	if isDeleted {
+		return storage.Link{}, storage.ErrDeletedURL
+	}
+	link.ExpiresAt = expiresAt.Time
+	return link, nil
+}
+
+// useClick атомарно учитывает переход по ссылке условным UPDATE, если лимит не исчерпан
+func (dbs *DatabaseStore) useClick(ctx context.Context, ID string) (string, error) {
+	var url string
+	err := dbs.db.QueryRowContext(ctx, UseClick, ID).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		//Лимит исчерпан параллельными переходами, либо ссылка удалена или истекла между запросами
+		return "", storage.ErrClicksExhausted
+	}
+	if err != nil {
+		return "", err
+	}
+	return url, nil
+}
+
+func (dbs *DatabaseStore) GetExpired(ctx context.Context, now time.Time) (map[string][]string, error) {
+	rows, err := dbs.db.QueryContext(ctx, SelectExpired, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]string)
+	for rows.Next() {
+		var user, shortID string
+		if err = rows.Scan(&user, &shortID); err != nil {
+			return nil, err
+		}
+		result[user] = append(result[user], shortID)
+	}
+	return result, rows.Err()
+}
+
+// nullTime переводит нулевое время в NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
 func (dbs *DatabaseStore) GetByUser(ctx context.Context, user string) ([]storage.UserRecord, error) {
 	result := make([]storage.UserRecord, 0)
 
@@ -158,8 +421,11 @@ func (dbs *DatabaseStore) GetByUser(ctx context.Context, user string) ([]storage
 }
 
 func (dbs *DatabaseStore) Close() error {
-	dbs.stopChan <- true
-	//Ждем пока воркер закончит работу
+	dbs.closeLock.Lock()
+	dbs.isClosed = true
+	close(dbs.deletedChan)
+	dbs.closeLock.Unlock()
+	//Ждем пока воркер применит все удаления из очереди
 	<-dbs.stopFinishedChan
 
 	if dbs.db != nil {
@@ -168,43 +434,3 @@ func (dbs *DatabaseStore) Close() error {
 
 	return nil
 }
-
-func (dbs *DatabaseStore) BatchDelete(_ context.Context, data []string, user string) {
-	go func() {
-		// async
-		dbs.deletedChan <- ChanMsg{
-			User:      user,
-			ShortURLs: data,
-		}
-	}()
-}
-
-func (dbs *DatabaseStore) deleteWorker() {
-	defer func() {
-		dbs.stopFinishedChan <- true
-	}()
-	for {
-		select {
-		case data := <-dbs.deletedChan:
-			dbs.deleteRecords(data)
-		case <-dbs.stopChan:
-			return
-		}
-	}
-}
-
-func (dbs *DatabaseStore) deleteRecords(data ChanMsg) {
-	//Async
-	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	rows, err := dbs.db.QueryContext(timeoutCtx, DeleteURLByID, data.User, pq.Array(data.ShortURLs))
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	if rows.Err() != nil {
-		log.Error(rows.Err())
-		return
-	}
-}
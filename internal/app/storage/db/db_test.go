@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// testDSNEnv переменная окружения с DSN тестовой базы, без нее тесты на Postgres пропускаются.
+// Данные в базе удаляются перед каждым тестом
+const testDSNEnv = "TEST_DATABASE_DSN"
+
+// testDatabaseDSN возвращает DSN тестовой базы. Имя базы должно содержать "test",
+// чтобы тесты не очистили рабочую базу по ошибочно заданной переменной
+func testDatabaseDSN(t *testing.T) string {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " is not set")
+	}
+	conninfo := dsn
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		conninfo, err = pq.ParseURL(dsn)
+		require.NoError(t, err)
+	}
+	for _, field := range strings.Fields(conninfo) {
+		if name, isDBName := strings.CutPrefix(field, "dbname="); isDBName && strings.Contains(strings.Trim(name, "'"), "test") {
+			return dsn
+		}
+	}
+	t.Fatalf("%s must name a test database with \"test\" in its name", testDSNEnv)
+	return ""
+}
+
+func TestDatabaseStore_Conformance(t *testing.T) {
+	dsn := testDatabaseDSN(t)
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		store, err := NewDatabaseStore(dsn, gen)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			err := store.Close()
+			require.NoError(t, err)
+		})
+		_, err = store.db.ExecContext(context.Background(), `TRUNCATE urls CASCADE;`)
+		require.NoError(t, err)
+		return store
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		return newGenStore(t, idgen.NewHash(common.GenHashedString))
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunTransfer(t, newStore)
+	storagetest.RunPurge(t, newStore)
+}
+
+func TestReadOnlyDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		want string
+	}{
+		{
+			name: "Test URL DSN",
+			dsn:  "postgres://user@localhost/shortener",
+			want: "dbname='shortener' host='localhost' user='user' default_transaction_read_only=on",
+		},
+		{
+			name: "Test conninfo DSN",
+			dsn:  "host=localhost dbname=shortener",
+			want: "host=localhost dbname=shortener default_transaction_read_only=on",
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readOnlyDSN(test.dsn)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestDatabaseStore_OpenReadOnly(t *testing.T) {
+	dsn := testDatabaseDSN(t)
+	store, err := storage.Open(dsn, storage.Options{Generator: idgen.NewHash(common.GenHashedString)})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	readOnly, err := storage.Open(dsn, storage.Options{Generator: idgen.NewHash(common.GenHashedString), ReadOnly: true})
+	require.NoError(t, err)
+	defer readOnly.Close()
+	//Изменения в сессии только для чтения отклоняет Postgres
+	_, err = readOnly.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	assert.Error(t, err)
+	_, err = readOnly.GetLink(context.Background(), "unknown-id")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestNewDatabaseStore_Error(t *testing.T) {
+	//Недоступная база возвращается ошибкой, а не завершает процесс
+	_, err := NewDatabaseStore("postgres://user@127.0.0.1:1/shortener_test?sslmode=disable&connect_timeout=1", idgen.NewHash(common.GenHashedString))
+	assert.Error(t, err)
+}
+
+// countingGen считает кандидатов, запрошенных у генератора
+type countingGen struct {
+	idgen.IDGenerator
+	calls int
+}
+
+func (cg *countingGen) Candidate(url string, attempt int) string {
+	cg.calls++
+	return cg.IDGenerator.Candidate(url, attempt)
+}
+
+func TestDatabaseStore_BatchSave_Existing(t *testing.T) {
+	dsn := testDatabaseDSN(t)
+	gen := &countingGen{IDGenerator: idgen.NewHash(common.GenHashedString)}
+	store, err := NewDatabaseStore(dsn, gen)
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.db.ExecContext(context.Background(), `TRUNCATE urls CASCADE;`)
+	require.NoError(t, err)
+
+	request := []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+		{CorrelationID: "2", OriginalURL: "https://test2.com"},
+	}
+	first, err := store.BatchSave(context.Background(), request, common.TestUser)
+	require.NoError(t, err)
+	require.Equal(t, 2, gen.calls)
+
+	//Для уже сохраненных URL ID не генерируются
+	second, err := store.BatchSave(context.Background(), append(request, storage.BatchSaveRequest{
+		CorrelationID: "3", OriginalURL: "https://test3.com",
+	}), common.TestUser)
+	require.NoError(t, err)
+	assert.Equal(t, 3, gen.calls)
+	for i := range first {
+		assert.Equal(t, first[i].ShortID, second[i].ShortID)
+		assert.Equal(t, storage.SaveExisted, second[i].Status)
+	}
+	assert.Equal(t, storage.SaveCreated, second[2].Status)
+}
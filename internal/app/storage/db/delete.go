@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// deleteQueueSize максимальное количество запросов на удаление в очереди, при заполнении BatchDelete ждет
+	deleteQueueSize = 1024
+	// deleteBatchSize количество ID, при котором накопленные удаления применяются не дожидаясь таймера
+	deleteBatchSize = 1000
+	// deleteFlushInterval максимальное время ожидания перед применением неполной пачки удалений
+	deleteFlushInterval = 100 * time.Millisecond
+)
+
+// DeleteURLByID удаляет пары (пользователь, ID) из двух параллельных массивов одним запросом
+const DeleteURLByID = `UPDATE urls SET is_deleted=TRUE,deleted_at=now() FROM unnest($1::text[],$2::text[]) AS d(user_id,short_url)
+	WHERE urls.user_id=d.user_id AND urls.short_url=d.short_url AND NOT urls.is_deleted;`
+
+type ChanMsg struct {
+	User      string
+	ShortURLs []string
+}
+
+func (dbs *DatabaseStore) BatchDelete(ctx context.Context, data []string, user string) {
+	dbs.closeLock.RLock()
+	defer dbs.closeLock.RUnlock()
+	if dbs.isClosed {
+		log.Error("DB delete error: storage is closed")
+		return
+	}
+
+	//При заполненной очереди вызывающий ждет, пока воркер ее разгрузит
+	select {
+	case dbs.deletedChan <- ChanMsg{User: user, ShortURLs: data}:
+	case <-ctx.Done():
+		log.Error("DB delete error: ", ctx.Err())
+	}
+}
+
+// DeleteQueueLen возвращает количество запросов на удаление, ожидающих воркер
+func (dbs *DatabaseStore) DeleteQueueLen() int {
+	return len(dbs.deletedChan)
+}
+
+func (dbs *DatabaseStore) deleteWorker() {
+	defer func() {
+		dbs.stopFinishedChan <- true
+	}()
+	runDeleteWorker(dbs.deletedChan, deleteBatchSize, deleteFlushInterval, dbs.deleteRecords)
+}
+
+// runDeleteWorker объединяет запросы на удаление в пачки по размеру или по таймеру,
+// после закрытия очереди применяет все оставшиеся удаления
+func runDeleteWorker(queue <-chan ChanMsg, batchSize int, interval time.Duration, flush func(users []string, ids []string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	users := make([]string, 0, batchSize)
+	ids := make([]string, 0, batchSize)
+	doFlush := func() {
+		if len(ids) == 0 {
+			return
+		}
+		flush(users, ids)
+		users = make([]string, 0, batchSize)
+		ids = make([]string, 0, batchSize)
+	}
+	for {
+		select {
+		case data, ok := <-queue:
+			if !ok {
+				doFlush()
+				return
+			}
+			for _, id := range data.ShortURLs {
+				users = append(users, data.User)
+				ids = append(ids, id)
+			}
+			if len(ids) >= batchSize {
+				doFlush()
+			}
+		case <-ticker.C:
+			doFlush()
+		}
+	}
+}
+
+func (dbs *DatabaseStore) deleteRecords(users []string, ids []string) {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := dbs.db.ExecContext(timeoutCtx, DeleteURLByID, pq.Array(users), pq.Array(ids)); err != nil {
+		log.Error("DB delete error: ", err)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushRecorder запоминает пачки удалений, переданные воркером
+type flushRecorder struct {
+	lock    sync.Mutex
+	batches [][]string
+}
+
+func (f *flushRecorder) flush(users []string, ids []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	batch := make([]string, len(ids))
+	for i := range ids {
+		batch[i] = users[i] + "/" + ids[i]
+	}
+	f.batches = append(f.batches, batch)
+}
+
+func (f *flushRecorder) get() [][]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.batches
+}
+
+func Test_runDeleteWorker(t *testing.T) {
+	t.Run("coalesce by size", func(t *testing.T) {
+		queue := make(chan ChanMsg, 10)
+		rec := &flushRecorder{}
+		queue <- ChanMsg{User: "u1", ShortURLs: []string{"a", "b"}}
+		queue <- ChanMsg{User: "u2", ShortURLs: []string{"c"}}
+		queue <- ChanMsg{User: "u1", ShortURLs: []string{"d"}}
+		close(queue)
+		runDeleteWorker(queue, 3, time.Hour, rec.flush)
+		assert.Equal(t, [][]string{{"u1/a", "u1/b", "u2/c"}, {"u1/d"}}, rec.get())
+	})
+
+	t.Run("flush by timer", func(t *testing.T) {
+		queue := make(chan ChanMsg, 10)
+		rec := &flushRecorder{}
+		done := make(chan bool)
+		go func() {
+			runDeleteWorker(queue, 100, 10*time.Millisecond, rec.flush)
+			done <- true
+		}()
+		queue <- ChanMsg{User: "u1", ShortURLs: []string{"a"}}
+		queue <- ChanMsg{User: "u2", ShortURLs: []string{"b"}}
+		assert.Eventually(t, func() bool {
+			return len(rec.get()) == 1
+		}, time.Second, 5*time.Millisecond)
+		close(queue)
+		<-done
+		assert.Equal(t, [][]string{{"u1/a", "u2/b"}}, rec.get())
+	})
+
+	t.Run("drain on close", func(t *testing.T) {
+		queue := make(chan ChanMsg, 100)
+		rec := &flushRecorder{}
+		for i := 0; i < 100; i++ {
+			queue <- ChanMsg{User: "u1", ShortURLs: []string{"a"}}
+		}
+		close(queue)
+		runDeleteWorker(queue, 1000, time.Hour, rec.flush)
+		assert.Len(t, rec.get(), 1)
+		assert.Len(t, rec.get()[0], 100)
+	})
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/lib/pq"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+const SelectURLForUpdate = `SELECT user_id,original_url,is_deleted FROM urls WHERE short_url=$1 FOR UPDATE;`
+const UpdateURLByID = `UPDATE urls SET original_url=$2,url_hash=` + URLHash + ` WHERE short_url=$1;`
+const InsertHistory = `INSERT INTO url_history (short_url,original_url,changed_at) VALUES ($1,$2,$3);`
+const SelectURLOwner = `SELECT user_id FROM urls WHERE short_url=$1;`
+const SelectHistory = `SELECT original_url,changed_at FROM url_history WHERE short_url=$1 ORDER BY id;`
+
+func (dbs *DatabaseStore) UpdateURL(ctx context.Context, id string, user string, url string) error {
+	if err := storage.CheckURL(url); err != nil {
+		return err
+	}
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//Откат транзакции если Commit не прошел
+	defer tx.Rollback()
+
+	var owner, currentURL string
+	var isDeleted bool
+	err = tx.QueryRowContext(ctx, SelectURLForUpdate, id).Scan(&owner, &currentURL, &isDeleted)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != user) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if isDeleted {
+		return storage.ErrDeletedURL
+	}
+	if currentURL == url {
+		return nil
+	}
+
+	if _, err = tx.ExecContext(ctx, InsertHistory, id, currentURL, time.Now()); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, UpdateURLByID, id, url)
+	var pgError *pq.Error
+	if errors.As(err, &pgError) && pgError.Code == pgerrcode.UniqueViolation {
+		//У пользователя уже есть ссылка на этот адрес
+		return storage.ErrDuplicateURL
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dbs *DatabaseStore) GetHistory(ctx context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	var owner string
+	err := dbs.db.QueryRowContext(ctx, SelectURLOwner, id).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != user) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbs.db.QueryContext(ctx, SelectHistory, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]storage.HistoryRecord, 0)
+	for rows.Next() {
+		var rec storage.HistoryRecord
+		if err = rows.Scan(&rec.URL, &rec.ChangedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, rec)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLockKey ключ advisory lock, чтобы параллельно запущенные экземпляры не применяли миграции одновременно
+const migrationsLockKey = 4_242_017
+
+const CreateMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name varchar(256) NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+const LockMigrations = `SELECT pg_advisory_lock($1)`
+const UnlockMigrations = `SELECT pg_advisory_unlock($1)`
+const SelectAppliedMigrations = `SELECT version,applied_at FROM schema_migrations`
+const InsertMigration = `INSERT INTO schema_migrations (version,name) VALUES ($1,$2)`
+const DeleteMigration = `DELETE FROM schema_migrations WHERE version=$1`
+
+// Migration версия схемы из пары файлов NNNN_name.up.sql и NNNN_name.down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus состояние миграции в базе, AppliedAt нулевое если миграция не применена
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// LoadMigrations читает встроенные файлы миграций, упорядоченные по версии
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		versionStr, name, hasName := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || !hasName || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %q", fileName)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, isExists := byVersion[version]
+		if !isExists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has different names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// Migrator применяет и откатывает миграции схемы
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up применяет все еще не примененные миграции, возвращает список примененных
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var result []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, isApplied := applied[migration.Version]; isApplied {
+				continue
+			}
+			if err = applyMigration(ctx, conn, migration.Up, InsertMigration, migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			log.Infof("Migration %d_%s applied", migration.Version, migration.Name)
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Down откатывает steps последних примененных миграций, возвращает список откаченных
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var result []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+			migration := m.migrations[i]
+			if _, isApplied := applied[migration.Version]; !isApplied {
+				continue
+			}
+			if err = applyMigration(ctx, conn, migration.Down, DeleteMigration, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			log.Infof("Migration %d_%s reverted", migration.Version, migration.Name)
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Status возвращает состояние всех известных миграций
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			result = append(result, MigrationStatus{
+				Migration: migration,
+				AppliedAt: applied[migration.Version],
+			})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// withLock выполняет f на отдельном соединении под сессионным advisory lock
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, LockMigrations, migrationsLockKey); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), UnlockMigrations, migrationsLockKey); err != nil {
+			log.Error("Unlock migrations error: ", err)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, CreateMigrationsTable); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+// appliedMigrations возвращает время применения миграций по версиям
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, SelectAppliedMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+	return result, rows.Err()
+}
+
+// applyMigration выполняет скрипт миграции и обновляет schema_migrations в одной транзакции
+func applyMigration(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//Откат транзакции если Commit не прошел
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		//Версии идут по порядку без пропусков
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func Test_loadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"m/0010_b.up.sql":   {Data: []byte("up b")},
+				"m/0010_b.down.sql": {Data: []byte("down b")},
+				"m/0002_a.up.sql":   {Data: []byte("up a")},
+				"m/0002_a.down.sql": {Data: []byte("down a")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "a", Up: "up a", Down: "down a"},
+				{Version: 10, Name: "b", Up: "up b", Down: "down b"},
+			},
+		},
+		{
+			name: "missing down file",
+			files: fstest.MapFS{
+				"m/0001_a.up.sql": {Data: []byte("up a")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad file name",
+			files: fstest.MapFS{
+				"m/first.up.sql": {Data: []byte("up")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "different names for one version",
+			files: fstest.MapFS{
+				"m/0001_a.up.sql":   {Data: []byte("up a")},
+				"m/0001_b.down.sql": {Data: []byte("down b")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			got, err := loadMigrations(test.files, "m")
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestMigrator_Up_SharedShortURL(t *testing.T) {
+	dsn := testDatabaseDSN(t)
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, `DROP TABLE IF EXISTS clicks, url_history, urls, schema_migrations CASCADE;`)
+	require.NoError(t, err)
+	//Схема и данные версии без миграций: одинаковый URL разных пользователей получал один ID
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, migrations[0].Up)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO urls (user_id,original_url,short_url,is_deleted) VALUES
+		('user-1','https://test.com','shared-id',false),
+		('user-2','https://test.com','shared-id',false),
+		('user-3','https://test.com','shared-id',true),
+		('user-1','https://test2.com','other-id',false);`)
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	ids := make(map[string]string)
+	rows, err := db.QueryContext(ctx, `SELECT user_id,original_url,short_url FROM urls;`)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var user, url, id string
+		require.NoError(t, rows.Scan(&user, &url, &id))
+		ids[user+" "+url] = id
+	}
+	require.NoError(t, rows.Err())
+
+	require.Len(t, ids, 4)
+	//ID остается у самой ранней записи, остальные владельцы получают новые уникальные ID
+	assert.Equal(t, "shared-id", ids["user-1 https://test.com"])
+	assert.Equal(t, "other-id", ids["user-1 https://test2.com"])
+	unique := make(map[string]bool)
+	for _, id := range ids {
+		unique[id] = true
+	}
+	assert.Len(t, unique, 4)
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS urls (
+	user_id varchar(36) NOT NULL,
+	original_url varchar(256) NOT NULL,
+	short_url varchar(32) NOT NULL,
+	is_deleted boolean NOT NULL,
+	PRIMARY KEY (user_id,original_url)
+);
+-- Расширение колонки под псевдонимы для таблиц, созданных до появления миграций
+ALTER TABLE urls ALTER COLUMN short_url TYPE varchar(32);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS urls_short_url_idx;
+-- Откат невозможен если сохранены URL длиннее 256 символов
+ALTER TABLE urls ALTER COLUMN original_url TYPE varchar(256);
+ALTER TABLE urls DROP CONSTRAINT urls_pkey;
+ALTER TABLE urls ADD PRIMARY KEY (user_id, original_url);
+ALTER TABLE urls DROP COLUMN url_hash;
@@ -0,0 +1,29 @@
+-- Ранние версии выдавали один ID на одинаковые URL разных пользователей. ID остается у самой ранней строки
+-- (порядок вставки известен только по физическому расположению строк), остальным владельцам выдается новый случайный ID
+CREATE INDEX urls_short_url_tmp_idx ON urls (short_url);
+DO $$
+DECLARE
+	duplicate record;
+	new_id text;
+BEGIN
+	FOR duplicate IN SELECT row_id FROM (
+		SELECT ctid AS row_id, row_number() OVER (PARTITION BY short_url ORDER BY ctid) AS n FROM urls
+	) ranked WHERE n > 1
+	LOOP
+		LOOP
+			new_id := substr(md5(random()::text || clock_timestamp()::text), 1, 10);
+			EXIT WHEN NOT EXISTS (SELECT 1 FROM urls WHERE short_url = new_id);
+		END LOOP;
+		UPDATE urls SET short_url = new_id WHERE ctid = duplicate.row_id;
+	END LOOP;
+END
+$$;
+DROP INDEX urls_short_url_tmp_idx;
+-- URL хранится без ограничения длины, уникальность по пользователю обеспечивает sha256 от URL
+ALTER TABLE urls ADD COLUMN url_hash char(64);
+UPDATE urls SET url_hash = encode(sha256(convert_to(original_url, 'UTF8')), 'hex');
+ALTER TABLE urls ALTER COLUMN url_hash SET NOT NULL;
+ALTER TABLE urls DROP CONSTRAINT urls_pkey;
+ALTER TABLE urls ADD PRIMARY KEY (user_id, url_hash);
+ALTER TABLE urls ALTER COLUMN original_url TYPE text;
+CREATE UNIQUE INDEX urls_short_url_idx ON urls (short_url);
@@ -0,0 +1,4 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+-- Время удаления ранее удаленных ссылок неизвестно, срок их хранения отсчитывается от миграции
+UPDATE urls SET deleted_at=now() WHERE is_deleted AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls (deleted_at) WHERE is_deleted;
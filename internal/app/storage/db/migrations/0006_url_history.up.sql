@@ -0,0 +1,8 @@
+-- Прежние адреса ссылок, удаляются вместе со ссылкой при окончательном удалении
+CREATE TABLE IF NOT EXISTS url_history (
+	id bigserial PRIMARY KEY,
+	short_url varchar(32) NOT NULL REFERENCES urls (short_url) ON DELETE CASCADE,
+	original_url text NOT NULL,
+	changed_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url);
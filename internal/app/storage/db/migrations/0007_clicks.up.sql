@@ -0,0 +1,9 @@
+-- События переходов по ссылкам для статистики analytics.DatabaseSink
+CREATE TABLE IF NOT EXISTS clicks (
+	short_url varchar(32) NOT NULL,
+	clicked_at timestamptz NOT NULL,
+	referrer text NOT NULL,
+	user_agent text NOT NULL,
+	client_ip varchar(64) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url);
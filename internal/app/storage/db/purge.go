@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+const PurgeDeletedURLs = `DELETE FROM urls WHERE is_deleted AND deleted_at<$1 RETURNING short_url;`
+
+func (dbs *DatabaseStore) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := dbs.db.QueryContext(ctx, PurgeDeletedURLs, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purged := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		purged = append(purged, id)
+	}
+	return purged, rows.Err()
+}
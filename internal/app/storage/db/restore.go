@@ -0,0 +1,29 @@
+package db
+
+import (
+	"context"
+	"github.com/lib/pq"
+)
+
+// RestoreURLByID восстанавливает удаленные и еще не истекшие ссылки пользователя
+const RestoreURLByID = `UPDATE urls SET is_deleted=false,deleted_at=NULL
+	WHERE user_id=$1 AND short_url=ANY($2::text[]) AND is_deleted AND (expires_at IS NULL OR expires_at>now())
+	RETURNING short_url;`
+
+func (dbs *DatabaseStore) BatchRestore(ctx context.Context, data []string, user string) ([]string, error) {
+	rows, err := dbs.db.QueryContext(ctx, RestoreURLByID, user, pq.Array(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	restored := make([]string, 0, len(data))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		restored = append(restored, id)
+	}
+	return restored, rows.Err()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/olkonon/shortener/internal/app/storage"
+)
+
+// SelectAll выбирает все записи, история адресов собирается в JSON массив
+const SelectAll = `SELECT short_url,original_url,user_id,is_deleted,deleted_at,expires_at,max_clicks,clicks,
+	(SELECT json_agg(json_build_object('URL',h.original_url,'ChangedAt',h.changed_at) ORDER BY h.id)
+		FROM url_history h WHERE h.short_url=urls.short_url)
+	FROM urls;`
+
+// ImportToTable вставляет запись как есть, при занятом ID или паре пользователь и URL запись пропускается
+const ImportToTable = `INSERT INTO urls (short_url,original_url,url_hash,user_id,is_deleted,deleted_at,expires_at,max_clicks,clicks)
+	VALUES ($1,$2,` + URLHash + `,$3,$4,CASE WHEN $4 THEN COALESCE($5,now()) END,$6,$7,$8) ON CONFLICT DO NOTHING`
+
+func (dbs *DatabaseStore) Export(ctx context.Context, f func(rec storage.Record) error) error {
+	rows, err := dbs.db.QueryContext(ctx, SelectAll)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec storage.Record
+		var deletedAt, expiresAt sql.NullTime
+		var history []byte
+		err = rows.Scan(&rec.ShortID, &rec.OriginalURL, &rec.User, &rec.IsDeleted, &deletedAt, &expiresAt, &rec.MaxClicks, &rec.Clicks, &history)
+		if err != nil {
+			return err
+		}
+		if history != nil {
+			if err = json.Unmarshal(history, &rec.History); err != nil {
+				return err
+			}
+		}
+		rec.DeletedAt = deletedAt.Time
+		rec.ExpiresAt = expiresAt.Time
+		if err = f(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (dbs *DatabaseStore) Import(ctx context.Context, data []storage.Record) (int, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	//Откат транзакции если Commit не прошел
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, ImportToTable)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	historyStmt, err := tx.PrepareContext(ctx, InsertHistory)
+	if err != nil {
+		return 0, err
+	}
+	defer historyStmt.Close()
+
+	imported := 0
+	for _, rec := range data {
+		result, err := stmt.ExecContext(ctx, rec.ShortID, rec.OriginalURL, rec.User, rec.IsDeleted,
+			nullTime(rec.DeletedAt), nullTime(rec.ExpiresAt), rec.MaxClicks, rec.Clicks)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			continue
+		}
+		//История переносится только вместе с добавленной записью
+		for _, val := range rec.History {
+			if _, err = historyStmt.ExecContext(ctx, rec.ShortID, val.URL, val.ChangedAt); err != nil {
+				return 0, err
+			}
+		}
+		imported++
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
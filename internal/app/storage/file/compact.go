@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bufio"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/storage"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultCompactMinSize минимальный размер журнала в байтах, с которого допускается автоматическое сжатие
+	DefaultCompactMinSize = common.DefaultCompactMinSize
+	// DefaultCompactRatio отношение количества записей в журнале к количеству актуальных записей для сжатия
+	DefaultCompactRatio = common.DefaultCompactRatio
+	// compactSuffix суффикс временного файла сжатого журнала
+	compactSuffix = ".compact"
+)
+
+// needCompaction проверяет пороги автоматического сжатия, вызывается под блокировкой
+func (fs *InFile) needCompaction() bool {
+	if fs.compacting || fs.compactMinSize <= 0 || fs.fileSize < fs.compactMinSize || fs.liveRecords == 0 {
+		return false
+	}
+	return float64(fs.fileRecords)/float64(fs.liveRecords) >= fs.compactRatio
+}
+
+// Compact переписывает актуальное состояние в новый файл и атомарно заменяет им журнал.
+// Запись нового файла идет без блокировки, записи, сделанные во время сжатия, дописываются в конце.
+// Если сжатие уже идет, повторное не запускается
+func (fs *InFile) Compact() error {
+	return fs.compact(false)
+}
+
+// compact выполняет сжатие журнала. С wait идущее сжатие дожидается и запускается новое,
+// так как снимок идущего сжатия может содержать уже убранные из хранилища записи
+func (fs *InFile) compact(wait bool) error {
+	if fs.readOnly {
+		return storage.ErrReadOnly
+	}
+	fs.lock.Lock()
+	for fs.compacting {
+		if !wait {
+			fs.lock.Unlock()
+			return nil
+		}
+		done := fs.compactDone
+		fs.lock.Unlock()
+		<-done
+		fs.lock.Lock()
+	}
+	fs.compacting = true
+	fs.compactDone = make(chan struct{})
+	snapshot := make([]Record, 0, fs.liveRecords)
+	for _, rec := range fs.byID {
+		snapshot = append(snapshot, rec)
+	}
+	fs.lock.Unlock()
+
+	tmpPath := fs.filePath + compactSuffix
+	err := fs.writeSnapshot(tmpPath, snapshot)
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	defer func() {
+		fs.compacting = false
+		fs.compactPending = nil
+		close(fs.compactDone)
+	}()
+	if err == nil {
+		err = fs.switchFile(tmpPath, len(snapshot))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// writeSnapshot записывает снимок состояния во временный файл
+func (fs *InFile) writeSnapshot(path string, snapshot []Record) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, rec := range snapshot {
+		data, err := encodeRecord(rec)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// switchFile дописывает записи, сделанные во время сжатия, и подменяет журнал, вызывается под блокировкой
+func (fs *InFile) switchFile(tmpPath string, snapshotRecords int) error {
+	f, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, rec := range fs.compactPending {
+		data, err := encodeRecord(rec)
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	//Атомарная замена журнала, старый файл остается целым до успешного rename
+	if err = os.Rename(tmpPath, fs.filePath); err != nil {
+		f.Close()
+		return err
+	}
+	//Sync каталога фиксирует сам rename на диске
+	if dir, err := os.Open(filepath.Dir(fs.filePath)); err == nil {
+		if err = dir.Sync(); err != nil {
+			log.Error("Sync storage dir error: ", err)
+		}
+		dir.Close()
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	fs.fileSize = info.Size()
+	fs.fileRecords = snapshotRecords + len(fs.compactPending)
+	return fs.journal.Swap(f)
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"context"
+	"time"
+)
+
+// Records возвращает снимок всех записей хранилища, включая удаленные
+func (fs *InFile) Records() []Record {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	result := make([]Record, 0, len(fs.byID))
+	for _, rec := range fs.byID {
+		result = append(result, rec)
+	}
+	return result
+}
+
+// Drop убирает записи из хранилища и сжимает журнал, чтобы они не вернулись после перезапуска
+func (fs *InFile) Drop(ids ...string) error {
+	fs.lock.Lock()
+	fs.drop(ids)
+	fs.lock.Unlock()
+	return fs.compact(true)
+}
+
+func (fs *InFile) PurgeDeleted(_ context.Context, before time.Time) ([]string, error) {
+	//Close дождется сжатия журнала, как и автоматического
+	fs.compactWG.Add(1)
+	defer fs.compactWG.Done()
+
+	fs.lock.Lock()
+	ids := make([]string, 0)
+	for id, rec := range fs.byID {
+		if rec.IsDeleted && rec.DeletedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	fs.drop(ids)
+	fs.lock.Unlock()
+
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	//Записи убираются из файла сжатием журнала, как и в Drop
+	return ids, fs.compact(true)
+}
+
+// drop убирает записи из обоих индексов, вызывается под блокировкой
+func (fs *InFile) drop(ids []string) {
+	for _, id := range ids {
+		rec, isExists := fs.byID[id]
+		if !isExists {
+			continue
+		}
+		delete(fs.byID, id)
+		if fs.byUser[rec.User][rec.URL] == id {
+			delete(fs.byUser[rec.User], rec.URL)
+		}
+		fs.liveRecords--
+	}
+}
@@ -1,16 +1,13 @@
 package file
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
-	"errors"
-	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
 	"github.com/olkonon/shortener/internal/app/storage"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 type Record struct {
@@ -18,231 +15,438 @@ type Record struct {
 	URL       string
 	User      string
 	IsDeleted bool
+	//DeletedAt время удаления, в журнале заполняется и у записи об удалении
+	DeletedAt time.Time
+	ExpiresAt time.Time
+	MaxClicks int
+	Clicks    int
+	//History прежние адреса ссылки
+	History []storage.HistoryRecord `json:",omitempty"`
+	//Tombstone запись журнала об удалении ссылки ID пользователем User, остальные поля не заполняются
+	Tombstone bool `json:",omitempty"`
 }
 
-func NewFileStorage(path string) *InFile {
+// check проверяет что по ссылке можно перейти
+func (r Record) check(now time.Time) error {
+	if r.IsDeleted {
+		return storage.ErrDeletedURL
+	}
+	if storage.IsExpired(r.ExpiresAt, now) {
+		return storage.ErrExpiredURL
+	}
+	if r.MaxClicks > 0 && r.Clicks >= r.MaxClicks {
+		return storage.ErrClicksExhausted
+	}
+	return nil
+}
+
+func NewFileStorage(path string, gen idgen.IDGenerator, opts ...Option) *InFile {
 	tmp := &InFile{
-		storeByID: make(map[string]map[string]Record),
-		filePath:  path,
+		byID:           make(map[string]Record),
+		byUser:         make(map[string]map[string]string),
+		filePath:       path,
+		gen:            gen,
+		compactMinSize: DefaultCompactMinSize,
+		compactRatio:   DefaultCompactRatio,
+		durability:     DurabilityGroup,
+		syncInterval:   DefaultSyncInterval,
+	}
+	for _, opt := range opts {
+		opt(tmp)
 	}
 	if err := tmp.loadCacheFromFile(); err != nil {
 		//Данная ошибка фатальна, так как означает что данные повреждены или операция I/O вызывает ошибки!
 		log.Fatal(err)
 	}
 	//Создание файла если нет или добавление в конец если есть
-	if f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600); err != nil {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
 		//Данная ошибка фатальна, так как означает что операция I/O вызывает ошибки!
 		log.Fatal(err)
-	} else {
-		tmp.f = f
+	}
+	tmp.journal, err = newJournal(f, tmp.durability, tmp.syncInterval)
+	if err != nil {
+		log.Fatal(err)
 	}
 	return tmp
 }
 
+// OpenReadOnly открывает существующий журнал только для чтения. Журнал не создается и не восстанавливается:
+// поврежденные строки и недописанный хвост пропускаются, изменение хранилища возвращает storage.ErrReadOnly
+func OpenReadOnly(path string, gen idgen.IDGenerator) (*InFile, error) {
+	tmp := &InFile{
+		byID:     make(map[string]Record),
+		byUser:   make(map[string]map[string]string),
+		filePath: path,
+		gen:      gen,
+		readOnly: true,
+	}
+	if err := tmp.loadCacheFromFile(); err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
 // InFile простое птокобезопасное хранилище на map реализующее интерфейс InFile, но хранящее свои данные в файле
 type InFile struct {
-	storeByID map[string]map[string]Record
-	filePath  string
-	f         *os.File
-	lock      sync.RWMutex
+	//byID первичный индекс по короткому ID
+	byID map[string]Record
+	//byUser вторичный индекс пользователь -> URL -> ID
+	byUser   map[string]map[string]string
+	filePath string
+	gen      idgen.IDGenerator
+	journal  *journal
+	lock     sync.RWMutex
+	//Режим надежности записи журнала
+	durability   string
+	syncInterval time.Duration
+
+	//Состояние журнала для принятия решения о сжатии
+	fileSize    int64
+	fileRecords int
+	liveRecords int
+	//Параметры и состояние сжатия журнала
+	compactMinSize int64
+	compactRatio   float64
+	compacting     bool
+	//compactDone закрывается по завершении текущего сжатия
+	compactDone    chan struct{}
+	compactPending []Record
+	compactWG      sync.WaitGroup
+	//deleteWG ожидание асинхронных удалений при закрытии
+	deleteWG sync.WaitGroup
+	//report результат восстановления журнала при запуске
+	report LoadReport
+	//readOnly хранилище открыто через OpenReadOnly, журнала для записи нет
+	readOnly bool
 }
 
-func (fs *InFile) GenIDByURL(_ context.Context, url string, user string) (string, error) {
+func (fs *InFile) GenIDByURL(_ context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	if err := storage.CheckURL(url); err != nil {
+		return "", err
+	}
+	newID, err := fs.genIDByURL(url, user, opts)
+	if err != nil {
+		return newID, err
+	}
+	//Ответ отдается только после сохранения записи на диск
+	return newID, fs.journal.Commit()
+}
+
+func (fs *InFile) genIDByURL(url string, user string, opts storage.SaveOptions) (string, error) {
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	if _, isExists := fs.storeByID[user]; !isExists {
-		fs.storeByID[user] = make(map[string]Record)
-	}
-
-	newID := common.GenHashedString(url)
-	if val, IDIsExists := fs.storeByID[user][newID]; IDIsExists {
-		if val.URL == url {
-			return newID, storage.ErrDuplicateURL
+	if existsID, isExists := fs.findByURL(url, user); isExists {
+		existsRecord := fs.byID[existsID]
+		if !storage.IsRenewable(existsRecord.IsDeleted, existsRecord.ExpiresAt, time.Now()) {
+			return existsID, storage.ErrDuplicateURL
 		}
-		return "", errors.New("can't generate new ID")
+		//Ссылка выдается заново под прежним ID, запрошенный псевдоним не применяется
+		return existsID, fs.renew(existsRecord, opts.ExpiresAt, opts.MaxClicks)
 	}
 
-	fs.storeByID[user][newID] = Record{
-		URL:       url,
-		ID:        newID,
-		User:      user,
-		IsDeleted: false,
+	newID := opts.Alias
+	if newID != "" {
+		//Псевдоним уникален среди всех пользователей
+		if !fs.isFreeID(newID) {
+			return "", storage.ErrAliasExists
+		}
+	} else {
+		var err error
+		newID, err = idgen.Generate(fs.gen, url, func(id string) (bool, error) {
+			return fs.isFreeID(id), nil
+		})
+		if err != nil {
+			return "", err
+		}
 	}
 
-	err := fs.appendToFile(Record{
+	return newID, fs.saveRecord(Record{
 		ID:        newID,
 		URL:       url,
 		User:      user,
 		IsDeleted: false,
+		ExpiresAt: opts.ExpiresAt,
+		MaxClicks: opts.MaxClicks,
 	})
-	return newID, err
+}
+
+// renew восстанавливает удаленную или истекшую ссылку с новыми параметрами, история адресов сохраняется.
+// Вызывается под блокировкой
+func (fs *InFile) renew(rec Record, expiresAt time.Time, maxClicks int) error {
+	return fs.saveRecord(rec.renewed(expiresAt, maxClicks))
+}
+
+// renewed возвращает копию записи, выданную заново с новыми параметрами
+func (rec Record) renewed(expiresAt time.Time, maxClicks int) Record {
+	rec.IsDeleted = false
+	rec.DeletedAt = time.Time{}
+	rec.ExpiresAt = expiresAt
+	rec.MaxClicks = maxClicks
+	rec.Clicks = 0
+	return rec
+}
+
+// findByURL ищет ID ранее сохраненного пользователем url, вызывается под блокировкой
+func (fs *InFile) findByURL(url string, user string) (string, bool) {
+	id, isExists := fs.byUser[user][url]
+	return id, isExists
+}
+
+// isFreeID проверяет что ID не занят ни одним пользователем, вызывается под блокировкой
+func (fs *InFile) isFreeID(id string) bool {
+	_, isExists := fs.byID[id]
+	return !isExists
+}
+
+// put сохраняет запись в обоих индексах кэша, вызывается под блокировкой
+func (fs *InFile) put(rec Record) {
+	if old, isExists := fs.byID[rec.ID]; !isExists {
+		fs.liveRecords++
+	} else if old.URL != rec.URL && fs.byUser[old.User][old.URL] == rec.ID {
+		//Адрес ссылки изменен, прежний адрес освобождается
+		delete(fs.byUser[old.User], old.URL)
+	}
+	if _, isExists := fs.byUser[rec.User]; !isExists {
+		fs.byUser[rec.User] = make(map[string]string)
+	}
+	fs.byID[rec.ID] = rec
+	fs.byUser[rec.User][rec.URL] = rec.ID
+}
+
+// saveRecord сохраняет запись в кэше и в файле, вызывается под блокировкой
+func (fs *InFile) saveRecord(rec Record) error {
+	return fs.saveRecords([]Record{rec})
+}
+
+// saveRecords сохраняет записи в файле одной записью журнала и только после этого в кэше,
+// чтобы при ошибке записи ни одна из них не стала видна. Вызывается под блокировкой
+func (fs *InFile) saveRecords(recs []Record) error {
+	if err := fs.appendToFile(recs...); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		fs.put(rec)
+	}
+	if fs.needCompaction() {
+		fs.compactWG.Add(1)
+		go func() {
+			defer fs.compactWG.Done()
+			if err := fs.Compact(); err != nil {
+				log.Error("File storage compaction error: ", err)
+			}
+		}()
+	}
+	return nil
 }
 
 func (fs *InFile) BatchSave(_ context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	//Пачка проверяется целиком до изменений, чтобы сохранить атомарность
+	if err := storage.CheckBatch(data); err != nil {
+		return nil, err
+	}
+	result, err := fs.batchSave(data, user)
+	if err != nil {
+		return result, err
+	}
+	//Ответ отдается только после сохранения пачки на диск
+	return result, fs.journal.Commit()
+}
+
+func (fs *InFile) batchSave(data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	if _, isExists := fs.storeByID[user]; !isExists {
-		fs.storeByID[user] = make(map[string]Record)
-	}
-
+	now := time.Now()
 	result := make([]storage.BatchSaveResponse, len(data))
+	//Записи пачки собираются целиком и сохраняются одной записью в журнал
+	batchUpdate := make([]Record, 0, len(data))
+	batchIDs := make(map[string]bool)
+	batchIDByURL := make(map[string]string)
 	for i, val := range data {
-		newID := common.GenHashedString(val.OriginalURL)
-		if existsURL, IDIsExists := fs.storeByID[user][newID]; IDIsExists {
-			if existsURL.URL == val.OriginalURL {
-				if existsURL.IsDeleted {
-					err := fs.appendToFile(Record{
-						ID:        newID,
-						URL:       val.OriginalURL,
-						User:      user,
-						IsDeleted: false,
-					})
-					if err != nil {
-						return nil, err
-					}
-					tmp := fs.storeByID[user][newID]
-					tmp.IsDeleted = false
-					fs.storeByID[user][newID] = tmp
-				} else {
-					result[i] = storage.BatchSaveResponse{
-						CorrelationID: val.CorrelationID,
-						ShortID:       newID,
-					}
-					continue
-				}
+		result[i].CorrelationID = val.CorrelationID
+		if existsID, isExists := batchIDByURL[val.OriginalURL]; isExists {
+			result[i].ShortID = existsID
+			result[i].Status = storage.SaveExisted
+			continue
+		}
+		if existsID, isExists := fs.findByURL(val.OriginalURL, user); isExists {
+			result[i].ShortID = existsID
+			result[i].Status = storage.SaveExisted
+			if existsRecord := fs.byID[existsID]; storage.IsRenewable(existsRecord.IsDeleted, existsRecord.ExpiresAt, now) {
+				batchUpdate = append(batchUpdate, existsRecord.renewed(val.ExpiresAt, val.MaxClicks))
+				result[i].Status = storage.SaveRestored
 			}
-			return result, errors.New("can't generate new ID")
+			batchIDByURL[val.OriginalURL] = existsID
+			continue
 		}
 
-		err := fs.appendToFile(Record{
-			ID:        newID,
-			URL:       val.OriginalURL,
-			User:      user,
-			IsDeleted: false,
+		newID, err := idgen.Generate(fs.gen, val.OriginalURL, func(id string) (bool, error) {
+			return !batchIDs[id] && fs.isFreeID(id), nil
 		})
 		if err != nil {
 			return nil, err
 		}
-
-		fs.storeByID[user][newID] = Record{
+		batchUpdate = append(batchUpdate, Record{
 			ID:        newID,
 			URL:       val.OriginalURL,
 			User:      user,
 			IsDeleted: false,
-		}
+			ExpiresAt: val.ExpiresAt,
+			MaxClicks: val.MaxClicks,
+		})
+		batchIDs[newID] = true
+		batchIDByURL[val.OriginalURL] = newID
+		result[i].ShortID = newID
+		result[i].Status = storage.SaveCreated
+	}
+	if len(batchUpdate) == 0 {
+		return result, nil
+	}
 
-		result[i] = storage.BatchSaveResponse{
-			CorrelationID: val.CorrelationID,
-			ShortID:       newID,
-		}
+	//Кэш меняется только после записи всей пачки, чтобы при ошибке данные не изменились
+	if err := fs.saveRecords(batchUpdate); err != nil {
+		return nil, err
 	}
 	return result, nil
 }
 
 func (fs *InFile) GetURLByID(_ context.Context, ID string) (string, error) {
 	fs.lock.RLock()
-	defer fs.lock.RUnlock()
+	url, isExists := fs.byID[ID]
+	fs.lock.RUnlock()
 
-	for _, userStore := range fs.storeByID {
-		if url, isExists := userStore[ID]; isExists {
-			if url.IsDeleted {
-				return "", storage.ErrDeletedURL
-			}
-			return url.URL, nil
+	if !isExists {
+		return "", storage.ErrNotFound
+	}
+	if url.MaxClicks > 0 {
+		//Переход по ссылке с лимитом учитывается под эксклюзивной блокировкой
+		longURL, err := fs.useClick(ID)
+		if err != nil {
+			return "", err
 		}
+		//Переход засчитывается только после сохранения счетчика на диск
+		return longURL, fs.journal.Commit()
 	}
+	if err := url.check(time.Now()); err != nil {
+		return "", err
+	}
+	return url.URL, nil
+}
 
-	return "", errors.New("unknown id")
+func (fs *InFile) GetLink(_ context.Context, ID string) (storage.Link, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	url, isExists := fs.byID[ID]
+	if !isExists {
+		return storage.Link{}, storage.ErrNotFound
+	}
+	if url.IsDeleted {
+		return storage.Link{}, storage.ErrDeletedURL
+	}
+	return storage.Link{OriginalURL: url.URL, ExpiresAt: url.ExpiresAt, MaxClicks: url.MaxClicks}, nil
 }
 
-func (fs *InFile) Close() error {
+// useClick атомарно проверяет лимит и учитывает переход по ссылке, счетчик сохраняется в файл
+func (fs *InFile) useClick(ID string) (string, error) {
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	if fs.f != nil {
-		return fs.f.Close()
+	url, isExists := fs.byID[ID]
+	if !isExists {
+		return "", storage.ErrNotFound
 	}
-	return nil
+	if err := url.check(time.Now()); err != nil {
+		return "", err
+	}
+	url.Clicks++
+	if err := fs.saveRecord(url); err != nil {
+		return "", err
+	}
+	return url.URL, nil
 }
 
-func (fs *InFile) appendToFile(rec Record) error {
-	data, err := json.Marshal(rec)
-	if err != nil {
-		return err
-	}
+func (fs *InFile) GetExpired(_ context.Context, now time.Time) (map[string][]string, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
 
-	//Записываем данные
-	_, err = fs.f.Write(data)
-	if err != nil {
-		return err
-	}
-	//Записываем разделитель
-	_, err = fs.f.Write([]byte("\n"))
-	if err != nil {
-		return err
-	}
-	//Вызываем sync для гарантии не потери данных (замедлит работу, но существенно повысит надежность,
-	//так количество операций записи много меньше количества операций чтения, существенного влияния на
-	//производительность сервиса оказать не должно)
-	err = fs.f.Sync()
-	if err != nil {
-		return err
+	result := make(map[string][]string)
+	for short, record := range fs.byID {
+		if !record.IsDeleted && storage.IsExpired(record.ExpiresAt, now) {
+			result[record.User] = append(result[record.User], short)
+		}
 	}
-	return nil
+	return result, nil
 }
 
-func (fs *InFile) loadCacheFromFile() error {
+func (fs *InFile) Close() error {
+	//Ждем записи асинхронных удалений и завершения фонового сжатия, чтобы оно не подменило закрытый файл
+	fs.deleteWG.Wait()
+	fs.compactWG.Wait()
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
-	f, err := os.OpenFile(fs.filePath, os.O_CREATE|os.O_RDONLY, 0600)
-	if err != nil {
-		return err
+	if fs.journal != nil {
+		return fs.journal.Close()
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Error("Close file error:", err)
-		}
-	}()
-	r := bufio.NewReader(f)
-	//Построчное чтение и декодирование файла
-	for {
-		data, _, err := r.ReadLine()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		//Парсинг json строки
-		var rec Record
-		if err = json.Unmarshal(data, &rec); err != nil {
+	return nil
+}
+
+func (fs *InFile) appendToFile(recs ...Record) error {
+	if fs.readOnly {
+		return storage.ErrReadOnly
+	}
+	var data []byte
+	for _, rec := range recs {
+		line, err := encodeRecord(rec)
+		if err != nil {
 			return err
 		}
-		if _, isExists := fs.storeByID[rec.User]; !isExists {
-			fs.storeByID[rec.User] = make(map[string]Record)
-		}
+		data = append(data, line...)
+	}
 
-		fs.storeByID[rec.User][rec.ID] = rec
+	//Записываем данные вместе с разделителями одним вызовом, момент fsync определяется режимом надежности журнала
+	err := fs.journal.Write(data)
+	if err != nil {
+		return err
+	}
+	fs.fileSize += int64(len(data))
+	fs.fileRecords += len(recs)
+	//Во время сжатия записи дублируются в новый журнал
+	if fs.compacting {
+		fs.compactPending = append(fs.compactPending, recs...)
 	}
 	return nil
 }
 
+// replayTombstone применяет запись об удалении из журнала к кэшу, вызывается под блокировкой
+func (fs *InFile) replayTombstone(rec Record) {
+	if original, exists := fs.byID[rec.ID]; exists && original.User == rec.User {
+		original.IsDeleted = true
+		original.DeletedAt = rec.DeletedAt
+		fs.byID[rec.ID] = original
+	}
+}
+
 func (fs *InFile) GetByUser(_ context.Context, user string) ([]storage.UserRecord, error) {
 	fs.lock.RLock()
 	defer fs.lock.RUnlock()
 
-	if urlList, isExists := fs.storeByID[user]; isExists {
-		if len(urlList) == 0 {
-			return nil, storage.ErrUserURLListEmpty
-		}
+	if urlList, isExists := fs.byUser[user]; isExists {
 		result := make([]storage.UserRecord, 0)
-		for short, original := range urlList {
-			result = append(result, storage.UserRecord{
-				OriginalURL: original.URL,
-				ShortID:     short,
-			})
+		for original, short := range urlList {
+			if !fs.byID[short].IsDeleted {
+				result = append(result, storage.UserRecord{
+					OriginalURL: original,
+					ShortID:     short,
+				})
+			}
+		}
+		if len(result) == 0 {
+			//Все ссылки пользователя удалены
+			return nil, storage.ErrUserURLListEmpty
 		}
 		return result, nil
 	}
@@ -250,19 +454,41 @@ func (fs *InFile) GetByUser(_ context.Context, user string) ([]storage.UserRecor
 }
 
 func (fs *InFile) BatchDelete(_ context.Context, data []string, user string) {
+	fs.deleteWG.Add(1)
 	go func() {
 		//Async
-		fs.lock.Lock()
-		defer fs.lock.Unlock()
-
-		for _, shortURL := range data {
-			if val, ok := fs.storeByID[user]; ok {
-				if _, exists := val[shortURL]; exists {
-					original := fs.storeByID[user][shortURL]
-					original.IsDeleted = true
-					fs.storeByID[user][shortURL] = original
-				}
-			}
+		defer fs.deleteWG.Done()
+		fs.batchDelete(data, user)
+		if err := fs.journal.Commit(); err != nil {
+			log.Error("File storage delete error: ", err)
 		}
 	}()
 }
+
+func (fs *InFile) batchDelete(data []string, user string) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	now := time.Now()
+	for _, shortURL := range data {
+		//Удалить можно только свою ссылку
+		original, exists := fs.byID[shortURL]
+		if !exists || original.User != user || original.IsDeleted {
+			continue
+		}
+		//Удаление сохраняется в журнал до изменения кэша, чтобы не потерять его после перезапуска
+		err := fs.appendToFile(Record{
+			ID:        shortURL,
+			User:      user,
+			DeletedAt: now,
+			Tombstone: true,
+		})
+		if err != nil {
+			log.Error("File storage delete error: ", err)
+			return
+		}
+		original.IsDeleted = true
+		original.DeletedAt = now
+		fs.byID[shortURL] = original
+	}
+}
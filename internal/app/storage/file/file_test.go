@@ -1,15 +1,22 @@
 package file
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
 	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -18,7 +25,7 @@ func init() {
 
 func TestFileStorage_GetURLByID(t *testing.T) {
 	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
-	store := NewFileStorage(filename)
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
 	defer func() {
 		err := os.Remove(filename)
 		require.NoError(t, err)
@@ -88,7 +95,7 @@ func TestFileStorage_GetURLByID(t *testing.T) {
 	for _, tt := range tests {
 		test := tt
 		t.Run(test.name, func(t *testing.T) {
-			fs := NewFileStorage(filename)
+			fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
 			defer func() {
 				err := fs.Close()
 				require.NoError(t, err)
@@ -107,7 +114,7 @@ func TestFileStorage_GetURLByID(t *testing.T) {
 
 func TestFileStorage_GenIDByURL(t *testing.T) {
 	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
-	store := NewFileStorage(filename)
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
 	defer func() {
 		err := os.Remove(filename)
 		require.NoError(t, err)
@@ -166,12 +173,12 @@ func TestFileStorage_GenIDByURL(t *testing.T) {
 	for _, tt := range tests {
 		test := tt
 		t.Run(test.name, func(t *testing.T) {
-			fs := NewFileStorage(filename)
+			fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
 			defer func() {
 				err := fs.Close()
 				require.NoError(t, err)
 			}()
-			got, err := fs.GenIDByURL(context.Background(), test.url, common.TestUser)
+			got, err := fs.GenIDByURL(context.Background(), test.url, common.TestUser, storage.SaveOptions{})
 			if (err != nil) != test.wantErr {
 				t.Errorf("GenIDByURL() error = %v, wantErr %v", err, test.wantErr)
 				return
@@ -185,7 +192,7 @@ func TestFileStorage_GenIDByURL(t *testing.T) {
 
 func TestFileStorage_BatchSave(t *testing.T) {
 	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
-	store := NewFileStorage(filename)
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
 	defer func() {
 		store.Close()
 		err := os.Remove(filename)
@@ -217,14 +224,17 @@ func TestFileStorage_BatchSave(t *testing.T) {
 		{
 			CorrelationID: "1",
 			ShortID:       testID1,
+			Status:        storage.SaveCreated,
 		},
 		{
 			CorrelationID: "2",
 			ShortID:       testID2,
+			Status:        storage.SaveCreated,
 		},
 		{
 			CorrelationID: "3",
 			ShortID:       testID3,
+			Status:        storage.SaveCreated,
 		},
 	}
 
@@ -232,3 +242,496 @@ func TestFileStorage_BatchSave(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, res, response)
 }
+
+func TestFileStorage_BatchSave_WriteError(t *testing.T) {
+	filename := "2F3E4D5C-6B7A-4988-A7B6-C5D4E3F2A1B0"
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString), WithDurability(DurabilityAlways, 0))
+	defer func() {
+		store.Close()
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+	ctx := context.Background()
+
+	deletedID, err := store.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	store.BatchDelete(ctx, []string{deletedID}, common.TestUser)
+	storagetest.WaitDeleted(t, store, deletedID)
+	fileRecords := store.fileRecords
+
+	//Запись пачки в журнал не удалась, ни одна ссылка пачки не должна стать видна
+	require.NoError(t, store.journal.f.Close())
+	_, err = store.BatchSave(ctx, []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test2.com"},
+		{CorrelationID: "2", OriginalURL: "https://test.com"},
+	}, common.TestUser)
+	require.Error(t, err)
+
+	_, err = store.GetByUser(ctx, common.TestUser)
+	assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+	_, err = store.GetLink(ctx, deletedID)
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	_, err = store.GetLink(ctx, common.GenHashedString("https://test2.com"))
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, fileRecords, store.fileRecords)
+}
+
+func TestFileStorage_GenIDByURL_Collision(t *testing.T) {
+	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
+	//Все URL хэшируются в один ID
+	store := NewFileStorage(filename, idgen.NewHash(func(string) string { return "collision" }))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	urls := []string{"https://test.com", "https://test2.com", "https://test3.com"}
+	ids := make(map[string]string)
+	for _, url := range urls {
+		id, err := store.GenIDByURL(context.Background(), url, common.TestUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		ids[id] = url
+	}
+	assert.Len(t, ids, len(urls))
+	err := store.Close()
+	require.NoError(t, err)
+
+	//После перезапуска все ссылки доступны
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	for id, url := range ids {
+		got, err := fs.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, url, got)
+	}
+}
+
+func TestFileStorage_MaxClicks(t *testing.T) {
+	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 2,
+	})
+	require.NoError(t, err)
+	got, err := store.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	err = store.Close()
+	require.NoError(t, err)
+
+	//Счетчик переходов сохраняется после перезапуска
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	_, err = fs.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	_, err = fs.GetURLByID(context.Background(), id)
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+}
+
+func TestFileStorage_Compact(t *testing.T) {
+	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
+	//Автоматическое сжатие отключено, чтобы проверить сжатие по запросу
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString), WithCompaction(0, 0))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 10,
+	})
+	require.NoError(t, err)
+	//Каждый переход дописывает запись в журнал
+	for i := 0; i < 5; i++ {
+		_, err = store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+	}
+	_, err = store.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 7, countLines(t, filename))
+
+	err = store.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, 2, countLines(t, filename))
+
+	//Запись после сжатия попадает в новый журнал
+	_, err = store.GenIDByURL(context.Background(), "https://test3.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	err = store.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 3, countLines(t, filename))
+
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	list, err := fs.GetByUser(context.Background(), common.TestUser)
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+	assert.Equal(t, 5, fs.byID[id].Clicks)
+}
+
+func TestFileStorage_Compact_Auto(t *testing.T) {
+	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString), WithCompaction(1, 2))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	id, err := store.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 1000,
+	})
+	require.NoError(t, err)
+	//Переходы идут параллельно с фоновым сжатием
+	for i := 0; i < 100; i++ {
+		_, err = store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+	}
+	err = store.Close()
+	require.NoError(t, err)
+	assert.Less(t, countLines(t, filename), 100)
+
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	assert.Equal(t, 100, fs.byID[id].Clicks)
+}
+
+func countLines(t *testing.T, filename string) int {
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileStorage_BatchDelete_Restart(t *testing.T) {
+	filename := "C8AA7A99-98E3-4D04-AD5D-2ED521F0D027"
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+		{CorrelationID: "2", OriginalURL: "https://test2.com"},
+		{CorrelationID: "3", OriginalURL: "https://test3.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+
+	//Чужую ссылку удалить нельзя
+	store.BatchDelete(context.Background(), []string{res[0].ShortID}, "other-user")
+	store.BatchDelete(context.Background(), []string{res[1].ShortID, res[2].ShortID}, common.TestUser)
+	//Close дожидается записи асинхронного удаления
+	err = store.Close()
+	require.NoError(t, err)
+
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	got, err := fs.GetURLByID(context.Background(), res[0].ShortID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	for _, val := range res[1:] {
+		_, err = fs.GetURLByID(context.Background(), val.ShortID)
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	}
+	list, err := fs.GetByUser(context.Background(), common.TestUser)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test.com", ShortID: res[0].ShortID}}, list)
+
+	//Удаление переживает сжатие журнала
+	err = fs.Compact()
+	require.NoError(t, err)
+	err = fs.Close()
+	require.NoError(t, err)
+
+	fs = NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	_, err = fs.GetURLByID(context.Background(), res[1].ShortID)
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+}
+
+func TestFileStorage_Durability(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		mode     string
+	}{
+		{name: "always", filename: "2D0C3B5E-6F0B-4E37-9B1C-0B7C1E4A9F11", mode: DurabilityAlways},
+		{name: "group", filename: "7E4D1F2A-3C5B-4A6D-8E9F-1A2B3C4D5E6F", mode: DurabilityGroup},
+		{name: "interval", filename: "9A8B7C6D-5E4F-4321-ABCD-EF0123456789", mode: DurabilityInterval},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				err := os.Remove(test.filename)
+				require.NoError(t, err)
+			}()
+			store := NewFileStorage(test.filename, idgen.NewHash(common.GenHashedString),
+				WithDurability(test.mode, 10*time.Millisecond))
+
+			//Параллельные записи подтверждаются общими fsync
+			var wg sync.WaitGroup
+			ids := make([]string, 50)
+			for i := range ids {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					id, err := store.GenIDByURL(context.Background(), fmt.Sprintf("https://test%d.com", i), common.TestUser, storage.SaveOptions{})
+					assert.NoError(t, err)
+					ids[i] = id
+				}(i)
+			}
+			wg.Wait()
+			err := store.Close()
+			require.NoError(t, err)
+
+			fs := NewFileStorage(test.filename, idgen.NewHash(common.GenHashedString))
+			defer func() {
+				err := fs.Close()
+				require.NoError(t, err)
+			}()
+			for i, id := range ids {
+				got, err := fs.GetURLByID(context.Background(), id)
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("https://test%d.com", i), got)
+			}
+		})
+	}
+}
+
+func TestNewJournal_UnknownMode(t *testing.T) {
+	_, err := newJournal(nil, "never", 0)
+	assert.Error(t, err)
+}
+
+// BenchmarkFileStorage_GetURLByID время редиректа не зависит от количества пользователей
+func BenchmarkFileStorage_GetURLByID(b *testing.B) {
+	for _, users := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			filename := "A1B2C3D4-E5F6-4789-ABCD-0123456789AB"
+			store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString), WithDurability(DurabilityInterval, time.Second))
+			defer func() {
+				err := store.Close()
+				require.NoError(b, err)
+				err = os.Remove(filename)
+				require.NoError(b, err)
+			}()
+			ids := make([]string, 0, users)
+			for i := 0; i < users; i++ {
+				id, err := store.GenIDByURL(context.Background(), fmt.Sprintf("https://test%d.com", i), fmt.Sprintf("user%d", i), storage.SaveOptions{})
+				require.NoError(b, err)
+				ids = append(ids, id)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetURLByID(context.Background(), ids[i%len(ids)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStorage_URLLength(t *testing.T) {
+	filename := "3F2E1D0C-BA98-4765-8432-10FEDCBA9876"
+	store := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := os.Remove(filename)
+		require.NoError(t, err)
+	}()
+
+	longURL := "https://test.com/?q=" + strings.Repeat("a", 1000)
+	id, err := store.GenIDByURL(context.Background(), longURL, common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	tooLongURL := "https://test.com/?q=" + strings.Repeat("a", storage.MaxURLLength)
+	_, err = store.GenIDByURL(context.Background(), tooLongURL, common.TestUser, storage.SaveOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLTooLong)
+	_, err = store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: tooLongURL},
+	}, common.TestUser)
+	assert.ErrorIs(t, err, storage.ErrURLTooLong)
+	err = store.Close()
+	require.NoError(t, err)
+
+	//Длинный URL переживает перезапуск
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := fs.Close()
+		require.NoError(t, err)
+	}()
+	got, err := fs.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, longURL, got)
+	assert.Equal(t, 1, fs.liveRecords)
+}
+
+func TestFileStorage_Conformance(t *testing.T) {
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		filename := "6B5A4938-2716-4054-A3B2-C1D0E9F8A7B6"
+		fs := NewFileStorage(filename, gen)
+		t.Cleanup(func() {
+			err := fs.Close()
+			require.NoError(t, err)
+			err = os.Remove(filename)
+			require.NoError(t, err)
+		})
+		return fs
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		return newGenStore(t, idgen.NewHash(common.GenHashedString))
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunTransfer(t, newStore)
+	storagetest.RunPurge(t, newStore)
+}
+
+func TestFileStorage_Open(t *testing.T) {
+	filename := "0E1D2C3B-4A59-4687-9786-A5B4C3D2E1F0"
+	store, err := storage.Open("file:"+filename+"?compact_min_size=0&durability=always", storage.Options{
+		Generator: idgen.NewHash(common.GenHashedString),
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := store.Close()
+		require.NoError(t, err)
+		err = os.Remove(filename)
+		require.NoError(t, err)
+	}()
+	fs, ok := store.(*InFile)
+	require.True(t, ok)
+	assert.Equal(t, filename, fs.filePath)
+	assert.Equal(t, int64(0), fs.compactMinSize)
+	assert.Equal(t, DefaultCompactRatio, fs.compactRatio)
+	assert.Equal(t, DurabilityAlways, fs.durability)
+
+	_, err = storage.Open("file:"+filename+"?compact_ratio=fast", storage.Options{})
+	assert.Error(t, err)
+}
+
+func TestFileStorage_Drop(t *testing.T) {
+	filename := "1F2E3D4C-5B6A-4798-8A9B-0C1D2E3F4A5B"
+	defer os.Remove(filename)
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	id1, err := fs.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	id2, err := fs.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	err = fs.Drop(id1)
+	require.NoError(t, err)
+	records := fs.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, id2, records[0].ID)
+	err = fs.Close()
+	require.NoError(t, err)
+
+	//Убранная запись не возвращается после перезапуска
+	fs = NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer fs.Close()
+	_, err = fs.GetURLByID(context.Background(), id1)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	got, err := fs.GetURLByID(context.Background(), id2)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+}
+
+func TestFileStorage_PurgeDeleted_Restart(t *testing.T) {
+	filename := "2A3B4C5D-6E7F-4809-9A1B-2C3D4E5F6A7B"
+	defer os.Remove(filename)
+	//Журнал старого формата без времени удаления
+	err := os.WriteFile(filename, []byte(`{"ID":"q3-report","URL":"https://test.com","User":"test-user"}`+"\n"+
+		`{"ID":"q3-report","User":"test-user","Tombstone":true}`+"\n"), 0600)
+	require.NoError(t, err)
+
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	//Срок хранения отсчитывается от загрузки журнала
+	purged, err := fs.PurgeDeleted(context.Background(), time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	purged, err = fs.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q3-report"}, purged)
+	err = fs.Close()
+	require.NoError(t, err)
+
+	fs = NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer fs.Close()
+	_, err = fs.GetURLByID(context.Background(), "q3-report")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestFileStorage_PurgeDeleted_DuringCompaction(t *testing.T) {
+	filename := "3B4C5D6E-7F8A-4910-8B2C-3D4E5F6A7B8C"
+	defer os.Remove(filename)
+	defer os.Remove(filename + compactSuffix)
+	fs := NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	id, err := fs.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	fs.BatchDelete(context.Background(), []string{id}, common.TestUser)
+	storagetest.WaitDeleted(t, fs, id)
+
+	//Идущее сжатие взяло снимок до очистки, удаленная запись в нем еще есть
+	snapshot := fs.Records()
+	fs.lock.Lock()
+	fs.compacting = true
+	fs.compactDone = make(chan struct{})
+	fs.lock.Unlock()
+
+	type purgeResult struct {
+		purged []string
+		err    error
+	}
+	resultChan := make(chan purgeResult, 1)
+	go func() {
+		purged, err := fs.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+		resultChan <- purgeResult{purged: purged, err: err}
+	}()
+	select {
+	case <-resultChan:
+		t.Fatal("PurgeDeleted must wait for the running compaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	//Завершение сжатия подменяет журнал снимком с удаленной записью
+	err = fs.writeSnapshot(filename+compactSuffix, snapshot)
+	require.NoError(t, err)
+	fs.lock.Lock()
+	err = fs.switchFile(filename+compactSuffix, len(snapshot))
+	fs.compacting = false
+	fs.compactPending = nil
+	close(fs.compactDone)
+	fs.lock.Unlock()
+	require.NoError(t, err)
+
+	result := <-resultChan
+	require.NoError(t, result.err)
+	assert.Equal(t, []string{id}, result.purged)
+	err = fs.Close()
+	require.NoError(t, err)
+
+	//Очищенная запись не возвращается после перезапуска
+	fs = NewFileStorage(filename, idgen.NewHash(common.GenHashedString))
+	defer fs.Close()
+	_, err = fs.GetURLByID(context.Background(), id)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,52 @@
+package file
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (fs *InFile) UpdateURL(_ context.Context, id string, user string, url string) error {
+	if err := storage.CheckURL(url); err != nil {
+		return err
+	}
+	if err := fs.updateURL(id, user, url); err != nil {
+		return err
+	}
+	//Ответ отдается только после сохранения на диск
+	return fs.journal.Commit()
+}
+
+func (fs *InFile) updateURL(id string, user string, url string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	rec, isExists := fs.byID[id]
+	if !isExists || rec.User != user {
+		return storage.ErrNotFound
+	}
+	if rec.IsDeleted {
+		return storage.ErrDeletedURL
+	}
+	if rec.URL == url {
+		return nil
+	}
+	if _, isExists = fs.findByURL(url, user); isExists {
+		return storage.ErrDuplicateURL
+	}
+
+	rec.History = storage.AppendHistory(rec.History, rec.URL, time.Now())
+	rec.URL = url
+	return fs.saveRecord(rec)
+}
+
+func (fs *InFile) GetHistory(_ context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	rec, isExists := fs.byID[id]
+	if !isExists || rec.User != user {
+		return nil, storage.ErrNotFound
+	}
+	return append([]storage.HistoryRecord{}, rec.History...), nil
+}
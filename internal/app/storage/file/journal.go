@@ -0,0 +1,177 @@
+package file
+
+import (
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/common"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+// Режимы надежности записи журнала
+const (
+	// DurabilityAlways fsync после каждой записи под блокировкой хранилища
+	DurabilityAlways = "always"
+	// DurabilityGroup параллельные записи подтверждаются одним общим fsync, ответ отдается после fsync
+	DurabilityGroup = common.DefaultFileDurability
+	// DurabilityInterval fsync выполняется фоном с заданным периодом, при сбое теряются записи за последний период
+	DurabilityInterval = "interval"
+)
+
+// DefaultSyncInterval период fsync по умолчанию для режима DurabilityInterval
+const DefaultSyncInterval = common.DefaultFileSyncPeriod
+
+func newJournal(f *os.File, mode string, interval time.Duration) (*journal, error) {
+	tmp := &journal{
+		f:    f,
+		mode: mode,
+	}
+	switch mode {
+	case DurabilityAlways, DurabilityGroup:
+	case DurabilityInterval:
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		tmp.stopChan = make(chan bool)
+		tmp.stopFinishedChan = make(chan bool)
+		go tmp.syncWorker(interval)
+	default:
+		return nil, fmt.Errorf("unknown file durability mode %q", mode)
+	}
+	return tmp, nil
+}
+
+// journal файл журнала с групповой фиксацией записей на диске
+type journal struct {
+	mode string
+
+	//lock защищает f и счетчики записей
+	lock    sync.Mutex
+	f       *os.File
+	written uint64
+	synced  uint64
+	//syncLock гарантирует, что fsync выполняет только один лидер группы
+	syncLock sync.Mutex
+
+	stopChan         chan bool
+	stopFinishedChan chan bool
+}
+
+// Write дописывает данные в конец журнала, в режиме DurabilityAlways сразу выполняет fsync
+func (j *journal) Write(data []byte) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, err := j.f.Write(data); err != nil {
+		return err
+	}
+	j.written++
+	if j.mode == DurabilityAlways {
+		if err := j.f.Sync(); err != nil {
+			return err
+		}
+		j.synced = j.written
+	}
+	return nil
+}
+
+// Commit ждет, пока все записанные к моменту вызова данные будут сохранены на диск.
+// Первый ожидающий становится лидером и выполняет fsync за всех, кто успел записать данные до него
+func (j *journal) Commit() error {
+	if j == nil {
+		//У хранилища только для чтения журнала нет и записывать нечего
+		return nil
+	}
+	if j.mode != DurabilityGroup {
+		//В режиме always данные уже на диске, в режиме interval подтверждение не ждет fsync
+		return nil
+	}
+	j.lock.Lock()
+	target := j.written
+	j.lock.Unlock()
+
+	j.syncLock.Lock()
+	defer j.syncLock.Unlock()
+	return j.syncTo(target)
+}
+
+// syncTo выполняет fsync, если записи до target еще не сохранены, вызывается под syncLock
+func (j *journal) syncTo(target uint64) error {
+	j.lock.Lock()
+	if j.synced >= target {
+		j.lock.Unlock()
+		return nil
+	}
+	//fsync покрывает и записи, сделанные пока лидер ждал своей очереди
+	written := j.written
+	f := j.f
+	j.lock.Unlock()
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	if written > j.synced {
+		j.synced = written
+	}
+	j.lock.Unlock()
+	return nil
+}
+
+// Swap подменяет файл журнала уже сохраненным на диск файлом f и закрывает старый
+func (j *journal) Swap(f *os.File) error {
+	j.syncLock.Lock()
+	defer j.syncLock.Unlock()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	old := j.f
+	j.f = f
+	j.synced = j.written
+	return old.Close()
+}
+
+// Close сохраняет на диск оставшиеся записи и закрывает файл журнала
+func (j *journal) Close() error {
+	if j.stopChan != nil {
+		j.stopChan <- true
+		<-j.stopFinishedChan
+	}
+	j.syncLock.Lock()
+	defer j.syncLock.Unlock()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+func (j *journal) syncWorker(interval time.Duration) {
+	defer func() {
+		j.stopFinishedChan <- true
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.lock.Lock()
+			target := j.written
+			j.lock.Unlock()
+
+			j.syncLock.Lock()
+			if err := j.syncTo(target); err != nil {
+				log.Error("File storage sync error: ", err)
+			}
+			j.syncLock.Unlock()
+		case <-j.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package file
+
+import "time"
+
+// Option настройка файлового хранилища
+type Option func(fs *InFile)
+
+// WithCompaction задает пороги автоматического сжатия журнала, нулевой minSize отключает автоматическое сжатие
+func WithCompaction(minSize int64, ratio float64) Option {
+	return func(fs *InFile) {
+		fs.compactMinSize = minSize
+		fs.compactRatio = ratio
+	}
+}
+
+// WithDurability задает режим надежности записи журнала и период fsync для режима DurabilityInterval
+func WithDurability(mode string, interval time.Duration) Option {
+	return func(fs *InFile) {
+		fs.durability = mode
+		fs.syncInterval = interval
+	}
+}
@@ -0,0 +1,230 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// quarantineSuffix суффикс файла, в который переносятся поврежденные строки журнала
+	quarantineSuffix = ".corrupt"
+	// recoverSuffix суффикс временного файла восстановленного журнала
+	recoverSuffix = ".recover"
+	// checksumLen длина контрольной суммы строки журнала в hex
+	checksumLen = 8
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errBadChecksum = errors.New("record checksum mismatch")
+	errBadFormat   = errors.New("bad record format")
+)
+
+// LoadReport результат загрузки журнала при запуске
+type LoadReport struct {
+	//Records количество загруженных записей
+	Records int
+	//Quarantined количество поврежденных строк, перенесенных в файл с суффиксом .corrupt
+	Quarantined int
+	//TruncatedBytes размер отрезанного недописанного хвоста журнала
+	TruncatedBytes int64
+}
+
+// Path возвращает путь к файлу журнала
+func (fs *InFile) Path() string {
+	return fs.filePath
+}
+
+// LoadReport возвращает результат восстановления журнала при запуске
+func (fs *InFile) LoadReport() LoadReport {
+	return fs.report
+}
+
+// encodeRecord сериализует запись в строку журнала вида "<crc32c> <json>\n"
+func encodeRecord(rec Record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, checksumLen+1+len(data)+1)
+	line = fmt.Appendf(line, "%08x ", crc32.Checksum(data, crcTable))
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+// decodeRecord разбирает строку журнала без разделителя, строки старого формата без контрольной суммы принимаются как есть
+func decodeRecord(line []byte) (Record, error) {
+	var rec Record
+	data := line
+	if len(line) == 0 || line[0] != '{' {
+		if len(line) < checksumLen+1 || line[checksumLen] != ' ' {
+			return rec, errBadFormat
+		}
+		var sum uint32
+		if _, err := fmt.Sscanf(string(line[:checksumLen]), "%08x", &sum); err != nil {
+			return rec, errBadFormat
+		}
+		data = line[checksumLen+1:]
+		if crc32.Checksum(data, crcTable) != sum {
+			return rec, errBadChecksum
+		}
+	}
+	err := json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// loadCacheFromFile восстанавливает кэш из журнала. Недописанный хвост отрезается,
+// поврежденные строки переносятся в карантин, после чего журнал перезаписывается без них
+func (fs *InFile) loadCacheFromFile() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	flag := os.O_CREATE | os.O_RDONLY
+	if fs.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(fs.filePath, flag, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Error("Close file error:", err)
+		}
+	}()
+
+	var quarantine [][]byte
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			//Строка без разделителя в конце файла - запись, прерванная сбоем
+			fs.report.TruncatedBytes = int64(len(line))
+			break
+		} else if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		rec, err := decodeRecord(bytes.TrimSuffix(line, []byte{'\n'}))
+		if err != nil {
+			log.Warnf("File storage: corrupt record at offset %d: %v", offset-int64(len(line)), err)
+			quarantine = append(quarantine, line)
+			continue
+		}
+		fs.fileSize += int64(len(line))
+		fs.fileRecords++
+		fs.report.Records++
+		fs.replayRecord(rec)
+	}
+	fs.report.Quarantined = len(quarantine)
+
+	if fs.readOnly {
+		//Журнал только для чтения не изменяется, поврежденные строки и хвост лишь пропускаются
+		log.Infof("File storage %s opened read-only: %d records loaded, %d corrupt records and %d bytes of incomplete tail skipped",
+			fs.filePath, fs.report.Records, fs.report.Quarantined, fs.report.TruncatedBytes)
+		return nil
+	}
+	if len(quarantine) > 0 {
+		if err = fs.writeQuarantine(quarantine); err != nil {
+			return err
+		}
+		if err = fs.rewriteFile(); err != nil {
+			return err
+		}
+	} else if fs.report.TruncatedBytes > 0 {
+		if err = os.Truncate(fs.filePath, offset); err != nil {
+			return err
+		}
+	}
+
+	if fs.report.Quarantined > 0 || fs.report.TruncatedBytes > 0 {
+		log.Warnf("File storage %s recovered: %d records loaded, %d corrupt records quarantined to %s, %d bytes of incomplete tail truncated",
+			fs.filePath, fs.report.Records, fs.report.Quarantined, fs.filePath+quarantineSuffix, fs.report.TruncatedBytes)
+	} else {
+		log.Infof("File storage %s loaded: %d records", fs.filePath, fs.report.Records)
+	}
+	return nil
+}
+
+// replayRecord применяет запись журнала к кэшу, вызывается под блокировкой
+func (fs *InFile) replayRecord(rec Record) {
+	//В журналах старого формата время удаления не записывалось, отсчет хранения удаленной ссылки идет с загрузки
+	if (rec.Tombstone || rec.IsDeleted) && rec.DeletedAt.IsZero() {
+		rec.DeletedAt = time.Now()
+	}
+	if rec.Tombstone {
+		fs.replayTombstone(rec)
+		return
+	}
+	fs.put(rec)
+}
+
+// writeQuarantine дописывает поврежденные строки в файл карантина для ручного разбора
+func (fs *InFile) writeQuarantine(lines [][]byte) error {
+	f, err := os.OpenFile(fs.filePath+quarantineSuffix, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err = f.Write(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rewriteFile заменяет журнал снимком загруженного состояния, вызывается под блокировкой до открытия журнала
+func (fs *InFile) rewriteFile() error {
+	snapshot := make([]Record, 0, fs.liveRecords)
+	for _, rec := range fs.byID {
+		snapshot = append(snapshot, rec)
+	}
+	tmpPath := fs.filePath + recoverSuffix
+	if err := fs.writeSnapshot(tmpPath, snapshot); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncFile(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fs.filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	info, err := os.Stat(fs.filePath)
+	if err != nil {
+		return err
+	}
+	fs.fileSize = info.Size()
+	fs.fileRecords = len(snapshot)
+	return nil
+}
+
+// syncFile сбрасывает содержимое файла на диск
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
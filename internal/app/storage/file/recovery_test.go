@@ -0,0 +1,139 @@
+package file
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestFileStorage_Recovery(t *testing.T) {
+	valid := func(id, url string) string {
+		data, err := encodeRecord(Record{ID: id, URL: url, User: common.TestUser})
+		require.NoError(t, err)
+		return string(data)
+	}
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     LoadReport
+		urls     map[string]string
+		corrupt  string
+	}{
+		{
+			name:     "clean",
+			filename: "5B1E0C2D-8A3F-4B6C-9D7E-0F1A2B3C4D5E",
+			content:  valid("a", "https://a.com") + valid("b", "https://b.com"),
+			want:     LoadReport{Records: 2},
+			urls:     map[string]string{"a": "https://a.com", "b": "https://b.com"},
+		},
+		{
+			name:     "legacy format without checksum",
+			filename: "6C2F1D3E-9B4A-4C7D-8E8F-1A2B3C4D5E6F",
+			content:  `{"ID":"a","URL":"https://a.com","User":"` + common.TestUser + `"}` + "\n" + valid("b", "https://b.com"),
+			want:     LoadReport{Records: 2},
+			urls:     map[string]string{"a": "https://a.com", "b": "https://b.com"},
+		},
+		{
+			name:     "torn tail",
+			filename: "7D3A2E4F-0C5B-4D8E-9F9A-2B3C4D5E6F7A",
+			content:  valid("a", "https://a.com") + `1234abcd {"ID":"b","UR`,
+			want:     LoadReport{Records: 1, TruncatedBytes: 22},
+			urls:     map[string]string{"a": "https://a.com"},
+		},
+		{
+			name:     "corrupt middle line",
+			filename: "8E4B3F5A-1D6C-4E9F-8A0B-3C4D5E6F7A8B",
+			content:  valid("a", "https://a.com") + "00000000 {\"ID\":\"x\"}\n" + valid("b", "https://b.com"),
+			want:     LoadReport{Records: 2, Quarantined: 1},
+			urls:     map[string]string{"a": "https://a.com", "b": "https://b.com"},
+			corrupt:  "00000000 {\"ID\":\"x\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			err := os.WriteFile(test.filename, []byte(test.content), 0600)
+			require.NoError(t, err)
+			defer func() {
+				err := os.Remove(test.filename)
+				require.NoError(t, err)
+				if test.corrupt != "" {
+					err = os.Remove(test.filename + quarantineSuffix)
+					require.NoError(t, err)
+				}
+			}()
+
+			store := NewFileStorage(test.filename, idgen.NewHash(common.GenHashedString))
+			assert.Equal(t, test.want, store.LoadReport())
+			for id, url := range test.urls {
+				got, err := store.GetURLByID(context.Background(), id)
+				require.NoError(t, err)
+				assert.Equal(t, url, got)
+			}
+			if test.corrupt != "" {
+				data, err := os.ReadFile(test.filename + quarantineSuffix)
+				require.NoError(t, err)
+				assert.Equal(t, test.corrupt, string(data))
+			}
+
+			//После восстановления журнал снова пригоден для записи и повторной загрузки
+			_, err = store.GenIDByURL(context.Background(), "https://new.com", common.TestUser, storage.SaveOptions{})
+			require.NoError(t, err)
+			err = store.Close()
+			require.NoError(t, err)
+
+			fs := NewFileStorage(test.filename, idgen.NewHash(common.GenHashedString))
+			assert.Equal(t, LoadReport{Records: fs.report.Records}, fs.LoadReport())
+			assert.Equal(t, len(test.urls)+1, fs.liveRecords)
+			err = fs.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFileStorage_OpenReadOnly(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		filename := "9F5C4A6B-2E7D-4F0A-9B1C-4D5E6F7A8B9C"
+		_, err := storage.Open("file:"+filename, storage.Options{Generator: idgen.NewHash(common.GenHashedString), ReadOnly: true})
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Stat(filename)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("corrupt journal is not changed", func(t *testing.T) {
+		filename := "0A6D5B7C-3F8E-4A1B-8C2D-5E6F7A8B9C0D"
+		defer os.Remove(filename)
+		data, err := encodeRecord(Record{ID: "a", URL: "https://a.com", User: common.TestUser})
+		require.NoError(t, err)
+		content := string(data) + "00000000 {\"ID\":\"x\"}\n" + `1234abcd {"ID":"b","UR`
+		err = os.WriteFile(filename, []byte(content), 0600)
+		require.NoError(t, err)
+
+		store, err := storage.Open("file:"+filename, storage.Options{Generator: idgen.NewHash(common.GenHashedString), ReadOnly: true})
+		require.NoError(t, err)
+		fs := store.(*InFile)
+		assert.Equal(t, LoadReport{Records: 1, Quarantined: 1, TruncatedBytes: 22}, fs.LoadReport())
+		got, err := fs.GetURLByID(context.Background(), "a")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a.com", got)
+
+		_, err = fs.GenIDByURL(context.Background(), "https://b.com", common.TestUser, storage.SaveOptions{})
+		assert.ErrorIs(t, err, storage.ErrReadOnly)
+		err = fs.Compact()
+		assert.ErrorIs(t, err, storage.ErrReadOnly)
+		err = fs.Close()
+		require.NoError(t, err)
+
+		after, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(after))
+		_, err = os.Stat(filename + quarantineSuffix)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
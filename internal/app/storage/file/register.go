@@ -0,0 +1,66 @@
+package file
+
+import (
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Scheme схема DSN файлового хранилища:
+// file:///path/to/file.json?compact_min_size=1048576&compact_ratio=2&durability=group&sync_interval=100ms
+const Scheme = "file"
+
+func init() {
+	storage.Register(Scheme, func(dsn string, opts storage.Options) (storage.Storage, error) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return nil, err
+		}
+		path, err := storage.FilePath(u)
+		if err != nil {
+			return nil, err
+		}
+		if opts.ReadOnly {
+			return OpenReadOnly(path, opts.Generator)
+		}
+		fileOpts, err := optionsFromQuery(u.Query())
+		if err != nil {
+			return nil, err
+		}
+		return NewFileStorage(path, opts.Generator, fileOpts...), nil
+	})
+}
+
+// optionsFromQuery разбирает параметры файлового хранилища из DSN, незаданные параметры остаются по умолчанию
+func optionsFromQuery(query url.Values) ([]Option, error) {
+	minSize := int64(DefaultCompactMinSize)
+	ratio := DefaultCompactRatio
+	durability := DurabilityGroup
+	syncInterval := DefaultSyncInterval
+
+	var err error
+	if val := query.Get("compact_min_size"); val != "" {
+		if minSize, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return nil, fmt.Errorf("bad compact_min_size %q: %w", val, err)
+		}
+	}
+	if val := query.Get("compact_ratio"); val != "" {
+		if ratio, err = strconv.ParseFloat(val, 64); err != nil {
+			return nil, fmt.Errorf("bad compact_ratio %q: %w", val, err)
+		}
+	}
+	if val := query.Get("durability"); val != "" {
+		durability = val
+	}
+	if val := query.Get("sync_interval"); val != "" {
+		if syncInterval, err = time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf("bad sync_interval %q: %w", val, err)
+		}
+	}
+	return []Option{
+		WithCompaction(minSize, ratio),
+		WithDurability(durability, syncInterval),
+	}, nil
+}
@@ -0,0 +1,39 @@
+package file
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (fs *InFile) BatchRestore(_ context.Context, data []string, user string) ([]string, error) {
+	restored, err := fs.batchRestore(data, user)
+	if err != nil {
+		return nil, err
+	}
+	//Ответ отдается только после сохранения на диск
+	return restored, fs.journal.Commit()
+}
+
+func (fs *InFile) batchRestore(data []string, user string) ([]string, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	now := time.Now()
+	restored := make([]string, 0, len(data))
+	for _, id := range data {
+		rec, isExists := fs.byID[id]
+		//Восстановить можно только свою удаленную и еще не истекшую ссылку
+		if !isExists || rec.User != user || !rec.IsDeleted || storage.IsExpired(rec.ExpiresAt, now) {
+			continue
+		}
+		rec.IsDeleted = false
+		rec.DeletedAt = time.Time{}
+		//Полная запись в журнале отменяет предыдущую запись об удалении
+		if err := fs.saveRecord(rec); err != nil {
+			return nil, err
+		}
+		restored = append(restored, id)
+	}
+	return restored, nil
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (fs *InFile) Export(_ context.Context, f func(rec storage.Record) error) error {
+	for _, rec := range fs.Records() {
+		err := f(storage.Record{
+			ShortID:     rec.ID,
+			OriginalURL: rec.URL,
+			User:        rec.User,
+			IsDeleted:   rec.IsDeleted,
+			DeletedAt:   rec.DeletedAt,
+			ExpiresAt:   rec.ExpiresAt,
+			MaxClicks:   rec.MaxClicks,
+			Clicks:      rec.Clicks,
+			History:     rec.History,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *InFile) Import(_ context.Context, data []storage.Record) (int, error) {
+	imported, err := fs.importRecords(data)
+	if err != nil {
+		return imported, err
+	}
+	//Загрузка считается выполненной только после сохранения на диск
+	return imported, fs.journal.Commit()
+}
+
+func (fs *InFile) importRecords(data []storage.Record) (int, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	now := time.Now()
+	imported := 0
+	for _, rec := range data {
+		if _, isExists := fs.findByURL(rec.OriginalURL, rec.User); isExists || !fs.isFreeID(rec.ShortID) {
+			continue
+		}
+		if rec.IsDeleted && rec.DeletedAt.IsZero() {
+			rec.DeletedAt = now
+		}
+		err := fs.saveRecord(Record{
+			ID:        rec.ShortID,
+			URL:       rec.OriginalURL,
+			User:      rec.User,
+			IsDeleted: rec.IsDeleted,
+			DeletedAt: rec.DeletedAt,
+			ExpiresAt: rec.ExpiresAt,
+			MaxClicks: rec.MaxClicks,
+			Clicks:    rec.Clicks,
+			History:   rec.History,
+		})
+		if err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
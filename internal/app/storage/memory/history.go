@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (im *InMemory) UpdateURL(_ context.Context, id string, user string, url string) error {
+	if err := storage.CheckURL(url); err != nil {
+		return err
+	}
+	im.lock.Lock()
+	defer im.lock.Unlock()
+
+	rec, isExists := im.byID[id]
+	if !isExists || rec.User != user {
+		return storage.ErrNotFound
+	}
+	if rec.IsDeleted {
+		return storage.ErrDeletedURL
+	}
+	if rec.OriginalURL == url {
+		return nil
+	}
+	if _, isExists = im.findByURL(url, user); isExists {
+		return storage.ErrDuplicateURL
+	}
+
+	rec.History = storage.AppendHistory(rec.History, rec.OriginalURL, time.Now())
+	rec.OriginalURL = url
+	im.put(id, rec)
+	return nil
+}
+
+func (im *InMemory) GetHistory(_ context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	im.lock.RLock()
+	defer im.lock.RUnlock()
+
+	rec, isExists := im.byID[id]
+	if !isExists || rec.User != user {
+		return nil, storage.ErrNotFound
+	}
+	return append([]storage.HistoryRecord{}, rec.History...), nil
+}
@@ -2,126 +2,273 @@ package memory
 
 import (
 	"context"
-	"errors"
-	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
 	"github.com/olkonon/shortener/internal/app/storage"
 	"sync"
+	"time"
 )
 
-func NewInMemory() *InMemory {
+func NewInMemory(gen idgen.IDGenerator) *InMemory {
 	return &InMemory{
-		storeByID: make(map[string]map[string]Record),
+		byID:   make(map[string]Record),
+		byUser: make(map[string]map[string]string),
+		gen:    gen,
 	}
 }
 
+// newInMemoryFrom создает InMemory с данными вида пользователь -> ID -> запись, используется в тестах
+func newInMemoryFrom(gen idgen.IDGenerator, data map[string]map[string]Record) *InMemory {
+	tmp := NewInMemory(gen)
+	for user, userStore := range data {
+		for id, rec := range userStore {
+			rec.User = user
+			tmp.put(id, rec)
+		}
+	}
+	return tmp
+}
+
 type Record struct {
 	OriginalURL string
+	User        string
 	IsDeleted   bool
+	//DeletedAt время удаления, по нему удаленная ссылка удаляется окончательно
+	DeletedAt time.Time
+	ExpiresAt time.Time
+	MaxClicks int
+	Clicks    int
+	//History прежние адреса ссылки
+	History []storage.HistoryRecord
+}
+
+// check проверяет что по ссылке можно перейти
+func (r Record) check(now time.Time) error {
+	if r.IsDeleted {
+		return storage.ErrDeletedURL
+	}
+	if storage.IsExpired(r.ExpiresAt, now) {
+		return storage.ErrExpiredURL
+	}
+	if r.MaxClicks > 0 && r.Clicks >= r.MaxClicks {
+		return storage.ErrClicksExhausted
+	}
+	return nil
+}
+
+// renewed возвращает удаленную или истекшую запись восстановленной с новыми параметрами, история адресов сохраняется
+func (r Record) renewed(expiresAt time.Time, maxClicks int) Record {
+	r.IsDeleted = false
+	r.DeletedAt = time.Time{}
+	r.ExpiresAt = expiresAt
+	r.MaxClicks = maxClicks
+	r.Clicks = 0
+	return r
 }
 
 // InMemory простое птокобезопасное хранилище на map реализующее интерфейс Storage
 type InMemory struct {
-	storeByID map[string]map[string]Record
-	lock      sync.RWMutex
+	//byID первичный индекс по короткому ID
+	byID map[string]Record
+	//byUser вторичный индекс пользователь -> URL -> ID
+	byUser map[string]map[string]string
+	gen    idgen.IDGenerator
+	lock   sync.RWMutex
 }
 
-func (im *InMemory) GenIDByURL(_ context.Context, url string, user string) (string, error) {
+func (im *InMemory) GenIDByURL(_ context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	if err := storage.CheckURL(url); err != nil {
+		return "", err
+	}
 	im.lock.Lock()
 	defer im.lock.Unlock()
 
-	if _, isExists := im.storeByID[user]; !isExists {
-		im.storeByID[user] = make(map[string]Record)
+	if existsID, isExists := im.findByURL(url, user); isExists {
+		existsRecord := im.byID[existsID]
+		if !storage.IsRenewable(existsRecord.IsDeleted, existsRecord.ExpiresAt, time.Now()) {
+			return existsID, storage.ErrDuplicateURL
+		}
+		//Ссылка выдается заново под прежним ID, запрошенный псевдоним не применяется
+		im.put(existsID, existsRecord.renewed(opts.ExpiresAt, opts.MaxClicks))
+		return existsID, nil
 	}
 
-	newID := common.GenHashedString(url)
-	userStore := im.storeByID[user]
-	if val, IDIsExists := userStore[newID]; IDIsExists {
-		if val.OriginalURL == url {
-			return newID, storage.ErrDuplicateURL
+	newID := opts.Alias
+	if newID != "" {
+		//Псевдоним уникален среди всех пользователей
+		if !im.isFreeID(newID) {
+			return "", storage.ErrAliasExists
+		}
+	} else {
+		var err error
+		newID, err = idgen.Generate(im.gen, url, func(id string) (bool, error) {
+			return im.isFreeID(id), nil
+		})
+		if err != nil {
+			return "", err
 		}
-		return "", errors.New("can't generate new ID")
 	}
 
-	im.storeByID[user][newID] = Record{OriginalURL: url,
+	im.put(newID, Record{OriginalURL: url,
+		User:      user,
 		IsDeleted: false,
-	}
+		ExpiresAt: opts.ExpiresAt,
+		MaxClicks: opts.MaxClicks,
+	})
 
 	return newID, nil
 }
 
+// put сохраняет запись в обоих индексах, вызывается под блокировкой
+func (im *InMemory) put(id string, rec Record) {
+	if old, isExists := im.byID[id]; isExists && old.OriginalURL != rec.OriginalURL && im.byUser[old.User][old.OriginalURL] == id {
+		//Адрес ссылки изменен, прежний адрес освобождается
+		delete(im.byUser[old.User], old.OriginalURL)
+	}
+	if _, isExists := im.byUser[rec.User]; !isExists {
+		im.byUser[rec.User] = make(map[string]string)
+	}
+	im.byID[id] = rec
+	im.byUser[rec.User][rec.OriginalURL] = id
+}
+
+// findByURL ищет ID ранее сохраненного пользователем url, вызывается под блокировкой
+func (im *InMemory) findByURL(url string, user string) (string, bool) {
+	id, isExists := im.byUser[user][url]
+	return id, isExists
+}
+
+// isFreeID проверяет что ID не занят ни одним пользователем, вызывается под блокировкой
+func (im *InMemory) isFreeID(id string) bool {
+	_, isExists := im.byID[id]
+	return !isExists
+}
+
 func (im *InMemory) BatchSave(_ context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	//Пачка проверяется целиком до изменений, чтобы сохранить атомарность
+	if err := storage.CheckBatch(data); err != nil {
+		return nil, err
+	}
 	im.lock.Lock()
 	defer im.lock.Unlock()
 
-	batchUpdate := make(map[string]string)
+	now := time.Now()
+	batchUpdate := make(map[string]Record)
+	batchIDByURL := make(map[string]string)
 	result := make([]storage.BatchSaveResponse, len(data))
-	if _, isExists := im.storeByID[user]; !isExists {
-		im.storeByID[user] = make(map[string]Record)
-	}
 
 	for i, val := range data {
-		newID := common.GenHashedString(val.OriginalURL)
-		if existsURL, IDIsExists := im.storeByID[user][newID]; IDIsExists {
-			if existsURL.OriginalURL == val.OriginalURL {
-				result[i] = storage.BatchSaveResponse{
-					CorrelationID: val.CorrelationID,
-					ShortID:       newID,
-				}
-				continue
+		result[i].CorrelationID = val.CorrelationID
+		if existsID, isExists := batchIDByURL[val.OriginalURL]; isExists {
+			result[i].ShortID = existsID
+			result[i].Status = storage.SaveExisted
+			continue
+		}
+		if existsID, isExists := im.findByURL(val.OriginalURL, user); isExists {
+			result[i].ShortID = existsID
+			result[i].Status = storage.SaveExisted
+			if existsRecord := im.byID[existsID]; storage.IsRenewable(existsRecord.IsDeleted, existsRecord.ExpiresAt, now) {
+				batchUpdate[existsID] = existsRecord.renewed(val.ExpiresAt, val.MaxClicks)
+				result[i].Status = storage.SaveRestored
 			}
-			return result, errors.New("can't generate new ID")
+			batchIDByURL[val.OriginalURL] = existsID
+			continue
 		}
 
-		batchUpdate[newID] = val.OriginalURL
-		result[i] = storage.BatchSaveResponse{
-			CorrelationID: val.CorrelationID,
-			ShortID:       newID,
+		newID, err := idgen.Generate(im.gen, val.OriginalURL, func(id string) (bool, error) {
+			_, inBatch := batchUpdate[id]
+			return !inBatch && im.isFreeID(id), nil
+		})
+		if err != nil {
+			return result, err
 		}
+
+		batchUpdate[newID] = Record{
+			OriginalURL: val.OriginalURL,
+			User:        user,
+			IsDeleted:   false,
+			ExpiresAt:   val.ExpiresAt,
+			MaxClicks:   val.MaxClicks,
+		}
+		batchIDByURL[val.OriginalURL] = newID
+		result[i].ShortID = newID
+		result[i].Status = storage.SaveCreated
 	}
 
 	//Это нужно для атомарности, чтобы если возникнет ошибка данные не изменились
 	for key, val := range batchUpdate {
-		im.storeByID[user][key] = Record{
-			OriginalURL: val,
-			IsDeleted:   false,
-		}
+		im.put(key, val)
 	}
 
 	return result, nil
 }
 
 func (im *InMemory) GetURLByID(_ context.Context, ID string) (string, error) {
+	im.lock.RLock()
+	url, isExists := im.byID[ID]
+	im.lock.RUnlock()
+
+	if !isExists {
+		return "", storage.ErrNotFound
+	}
+	if url.MaxClicks > 0 {
+		//Переход по ссылке с лимитом учитывается под эксклюзивной блокировкой
+		return im.useClick(ID)
+	}
+	if err := url.check(time.Now()); err != nil {
+		return "", err
+	}
+	return url.OriginalURL, nil
+}
+
+func (im *InMemory) GetLink(_ context.Context, ID string) (storage.Link, error) {
 	im.lock.RLock()
 	defer im.lock.RUnlock()
-	for _, userStore := range im.storeByID {
-		if url, isExists := userStore[ID]; isExists {
-			if url.IsDeleted {
-				return "", storage.ErrDeletedURL
-			}
-			return url.OriginalURL, nil
-		}
+
+	url, isExists := im.byID[ID]
+	if !isExists {
+		return storage.Link{}, storage.ErrNotFound
+	}
+	if url.IsDeleted {
+		return storage.Link{}, storage.ErrDeletedURL
 	}
+	return storage.Link{OriginalURL: url.OriginalURL, ExpiresAt: url.ExpiresAt, MaxClicks: url.MaxClicks}, nil
+}
+
+// useClick атомарно проверяет лимит и учитывает переход по ссылке
+func (im *InMemory) useClick(ID string) (string, error) {
+	im.lock.Lock()
+	defer im.lock.Unlock()
 
-	return "", errors.New("unknown id")
+	url, isExists := im.byID[ID]
+	if !isExists {
+		return "", storage.ErrNotFound
+	}
+	if err := url.check(time.Now()); err != nil {
+		return "", err
+	}
+	url.Clicks++
+	im.byID[ID] = url
+	return url.OriginalURL, nil
 }
 
 func (im *InMemory) GetByUser(_ context.Context, user string) ([]storage.UserRecord, error) {
 	im.lock.RLock()
 	defer im.lock.RUnlock()
 
-	if urlList, isExists := im.storeByID[user]; isExists {
-		if len(urlList) == 0 {
-			return nil, storage.ErrUserURLListEmpty
-		}
+	if urlList, isExists := im.byUser[user]; isExists {
 		result := make([]storage.UserRecord, 0)
-		for short, original := range urlList {
-			if !original.IsDeleted {
+		for original, short := range urlList {
+			if !im.byID[short].IsDeleted {
 				result = append(result, storage.UserRecord{
-					OriginalURL: original.OriginalURL,
+					OriginalURL: original,
 					ShortID:     short,
 				})
 			}
 		}
+		if len(result) == 0 {
+			//Все ссылки пользователя удалены
+			return nil, storage.ErrUserURLListEmpty
+		}
 		return result, nil
 	}
 	return nil, storage.ErrUserURLListEmpty
@@ -133,18 +280,31 @@ func (im *InMemory) BatchDelete(_ context.Context, data []string, user string) {
 		im.lock.Lock()
 		defer im.lock.Unlock()
 
+		now := time.Now()
 		for _, shortURL := range data {
-			if val, ok := im.storeByID[user]; ok {
-				if _, exists := val[shortURL]; exists {
-					original := im.storeByID[user][shortURL]
-					original.IsDeleted = true
-					im.storeByID[user][shortURL] = original
-				}
+			//Удалить можно только свою ссылку
+			if original, exists := im.byID[shortURL]; exists && original.User == user && !original.IsDeleted {
+				original.IsDeleted = true
+				original.DeletedAt = now
+				im.byID[shortURL] = original
 			}
 		}
 	}()
 }
 
+func (im *InMemory) GetExpired(_ context.Context, now time.Time) (map[string][]string, error) {
+	im.lock.RLock()
+	defer im.lock.RUnlock()
+
+	result := make(map[string][]string)
+	for short, record := range im.byID {
+		if !record.IsDeleted && storage.IsExpired(record.ExpiresAt, now) {
+			result[record.User] = append(result[record.User], short)
+		}
+	}
+	return result, nil
+}
+
 func (im *InMemory) Close() error {
 	return nil
 }
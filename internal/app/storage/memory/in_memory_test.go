@@ -2,13 +2,21 @@ package memory
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
 	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -60,9 +68,7 @@ func TestInMemory_GetURLByID(t *testing.T) {
 	for _, tt := range tests {
 		test := tt
 		t.Run(test.name, func(t *testing.T) {
-			ims := &InMemory{
-				storeByID: test.fields.storeByID,
-			}
+			ims := newInMemoryFrom(idgen.NewHash(common.GenHashedString), test.fields.storeByID)
 			defer func() {
 				err := ims.Close()
 				require.NoError(t, err)
@@ -84,7 +90,9 @@ func TestInMemory_GenIDByURL(t *testing.T) {
 		storeByID map[string]map[string]Record
 	}
 	type args struct {
-		url string
+		url   string
+		user  string
+		alias string
 	}
 
 	testURL := "https://test.com"
@@ -95,7 +103,7 @@ func TestInMemory_GenIDByURL(t *testing.T) {
 		fields  fields
 		args    args
 		want    string
-		wantErr bool
+		wantErr error
 	}{
 		{
 			name: "generate from existed URL",
@@ -105,36 +113,67 @@ func TestInMemory_GenIDByURL(t *testing.T) {
 					IsDeleted:   false,
 				}}},
 			},
-			args:    struct{ url string }{url: testURL},
+			args:    args{url: testURL, user: common.TestUser},
 			want:    testID,
-			wantErr: true,
+			wantErr: storage.ErrDuplicateURL,
+		},
+		{
+			name: "reserve free alias",
+			fields: fields{
+				storeByID: map[string]map[string]Record{common.TestUser: {testID: Record{
+					OriginalURL: testURL,
+					IsDeleted:   false,
+				}}},
+			},
+			args:    args{url: "https://test2.com", user: common.TestUser, alias: "q3-report"},
+			want:    "q3-report",
+			wantErr: nil,
+		},
+		{
+			name: "reserve alias taken by other user",
+			fields: fields{
+				storeByID: map[string]map[string]Record{common.TestUser: {"q3-report": Record{
+					OriginalURL: testURL,
+					IsDeleted:   false,
+				}}},
+			},
+			args:    args{url: "https://test2.com", user: "other-user", alias: "q3-report"},
+			want:    "",
+			wantErr: storage.ErrAliasExists,
+		},
+		{
+			name: "reserve alias for existed URL",
+			fields: fields{
+				storeByID: map[string]map[string]Record{common.TestUser: {testID: Record{
+					OriginalURL: testURL,
+					IsDeleted:   false,
+				}}},
+			},
+			args:    args{url: testURL, user: common.TestUser, alias: "q3-report"},
+			want:    testID,
+			wantErr: storage.ErrDuplicateURL,
 		},
 	}
 
 	for _, tt := range tests {
 		test := tt
 		t.Run(test.name, func(t *testing.T) {
-			ims := &InMemory{
-				storeByID: test.fields.storeByID,
-			}
+			ims := newInMemoryFrom(idgen.NewHash(common.GenHashedString), test.fields.storeByID)
 			defer func() {
 				err := ims.Close()
 				require.NoError(t, err)
 			}()
-			got, err := ims.GenIDByURL(context.Background(), test.args.url, common.TestUser)
-			if (err != nil) != test.wantErr {
-				t.Errorf("GenIDByURL() error = %v, wantErr %v", err, test.wantErr)
-				return
-			}
-			if got != test.want {
-				t.Errorf("GenIDByURL() got = %v, want %v", got, test.want)
-			}
+			got, err := ims.GenIDByURL(context.Background(), test.args.url, test.args.user, storage.SaveOptions{
+				Alias: test.args.alias,
+			})
+			assert.ErrorIs(t, err, test.wantErr)
+			assert.Equal(t, test.want, got)
 		})
 	}
 }
 
 func TestInMemory_BatchSave(t *testing.T) {
-	ims := NewInMemory()
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
 	testURL1 := "https://test.com"
 	testID1 := common.GenHashedString(testURL1)
 	testURL2 := "https://test2.com"
@@ -161,14 +200,17 @@ func TestInMemory_BatchSave(t *testing.T) {
 		{
 			CorrelationID: "1",
 			ShortID:       testID1,
+			Status:        storage.SaveCreated,
 		},
 		{
 			CorrelationID: "2",
 			ShortID:       testID2,
+			Status:        storage.SaveCreated,
 		},
 		{
 			CorrelationID: "3",
 			ShortID:       testID3,
+			Status:        storage.SaveCreated,
 		},
 	}
 
@@ -176,3 +218,215 @@ func TestInMemory_BatchSave(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, res, response)
 }
+
+func TestInMemory_GenIDByURL_Collision(t *testing.T) {
+	//Все URL хэшируются в один ID
+	ims := NewInMemory(idgen.NewHash(func(string) string { return "collision" }))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	urls := []string{"https://test.com", "https://test2.com", "https://test3.com"}
+	ids := make(map[string]string)
+	for _, url := range urls {
+		id, err := ims.GenIDByURL(context.Background(), url, common.TestUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		ids[id] = url
+	}
+	assert.Len(t, ids, len(urls))
+
+	for id, url := range ids {
+		got, err := ims.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, url, got)
+	}
+
+	res, err := ims.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test4.com"},
+		{CorrelationID: "2", OriginalURL: "https://test5.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+	assert.NotEqual(t, res[0].ShortID, res[1].ShortID)
+}
+
+func TestInMemory_Expiration(t *testing.T) {
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Now()
+	expiredID, err := ims.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		ExpiresAt: now.Add(-time.Second),
+	})
+	require.NoError(t, err)
+	liveID, err := ims.GenIDByURL(context.Background(), "https://test2.com", common.TestUser, storage.SaveOptions{
+		ExpiresAt: now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = ims.GetURLByID(context.Background(), expiredID)
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	got, err := ims.GetURLByID(context.Background(), liveID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+
+	expired, err := ims.GetExpired(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{common.TestUser: {expiredID}}, expired)
+
+	//Истекшая ссылка удаляется тем же механизмом что и BatchDelete
+	storage.ReapExpired(context.Background(), ims, now)
+	assert.Eventually(t, func() bool {
+		_, err := ims.GetURLByID(context.Background(), expiredID)
+		return errors.Is(err, storage.ErrDeletedURL)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInMemory_MaxClicks(t *testing.T) {
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	id, err := ims.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		got, err := ims.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+	}
+	_, err = ims.GetURLByID(context.Background(), id)
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+}
+
+func TestInMemory_MaxClicks_Concurrent(t *testing.T) {
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	//Одноразовая ссылка
+	id, err := ims.GenIDByURL(context.Background(), "https://test.com", common.TestUser, storage.SaveOptions{
+		MaxClicks: 1,
+	})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var success atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ims.GetURLByID(context.Background(), id); err == nil {
+				success.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), success.Load())
+}
+
+// BenchmarkInMemory_GetURLByID время редиректа не зависит от количества пользователей
+func BenchmarkInMemory_GetURLByID(b *testing.B) {
+	for _, users := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+			ids := make([]string, 0, users)
+			for i := 0; i < users; i++ {
+				id, err := ims.GenIDByURL(context.Background(), fmt.Sprintf("https://test%d.com", i), fmt.Sprintf("user%d", i), storage.SaveOptions{})
+				require.NoError(b, err)
+				ids = append(ids, id)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ims.GetURLByID(context.Background(), ids[i%len(ids)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemory_BatchDelete(t *testing.T) {
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	res, err := ims.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test.com"},
+		{CorrelationID: "2", OriginalURL: "https://test2.com"},
+	}, common.TestUser)
+	require.NoError(t, err)
+
+	//Чужую ссылку удалить нельзя
+	ims.BatchDelete(context.Background(), []string{res[0].ShortID}, "other-user")
+	ims.BatchDelete(context.Background(), []string{res[1].ShortID}, common.TestUser)
+	assert.Eventually(t, func() bool {
+		_, err := ims.GetURLByID(context.Background(), res[1].ShortID)
+		return errors.Is(err, storage.ErrDeletedURL)
+	}, time.Second, 10*time.Millisecond)
+
+	got, err := ims.GetURLByID(context.Background(), res[0].ShortID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	list, err := ims.GetByUser(context.Background(), common.TestUser)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test.com", ShortID: res[0].ShortID}}, list)
+}
+
+func TestInMemory_URLLength(t *testing.T) {
+	ims := NewInMemory(idgen.NewHash(common.GenHashedString))
+	defer func() {
+		err := ims.Close()
+		require.NoError(t, err)
+	}()
+
+	longURL := "https://test.com/?q=" + strings.Repeat("a", 1000)
+	id, err := ims.GenIDByURL(context.Background(), longURL, common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	got, err := ims.GetURLByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, longURL, got)
+
+	tooLongURL := "https://test.com/?q=" + strings.Repeat("a", storage.MaxURLLength)
+	_, err = ims.GenIDByURL(context.Background(), tooLongURL, common.TestUser, storage.SaveOptions{})
+	assert.ErrorIs(t, err, storage.ErrURLTooLong)
+
+	//Пачка с длинным URL не сохраняется целиком
+	_, err = ims.BatchSave(context.Background(), []storage.BatchSaveRequest{
+		{CorrelationID: "1", OriginalURL: "https://test2.com"},
+		{CorrelationID: "2", OriginalURL: tooLongURL},
+	}, common.TestUser)
+	assert.ErrorIs(t, err, storage.ErrURLTooLong)
+	list, err := ims.GetByUser(context.Background(), common.TestUser)
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestInMemory_Conformance(t *testing.T) {
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		ims := NewInMemory(gen)
+		t.Cleanup(func() {
+			err := ims.Close()
+			require.NoError(t, err)
+		})
+		return ims
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		return newGenStore(t, idgen.NewHash(common.GenHashedString))
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunTransfer(t, newStore)
+	storagetest.RunPurge(t, newStore)
+}
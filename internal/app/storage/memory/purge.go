@@ -0,0 +1,24 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+func (im *InMemory) PurgeDeleted(_ context.Context, before time.Time) ([]string, error) {
+	im.lock.Lock()
+	defer im.lock.Unlock()
+
+	purged := make([]string, 0)
+	for id, rec := range im.byID {
+		if !rec.IsDeleted || !rec.DeletedAt.Before(before) {
+			continue
+		}
+		delete(im.byID, id)
+		if im.byUser[rec.User][rec.OriginalURL] == id {
+			delete(im.byUser[rec.User], rec.OriginalURL)
+		}
+		purged = append(purged, id)
+	}
+	return purged, nil
+}
@@ -0,0 +1,12 @@
+package memory
+
+import "github.com/olkonon/shortener/internal/app/storage"
+
+// Scheme схема DSN хранилища в памяти: memory://
+const Scheme = "memory"
+
+func init() {
+	storage.Register(Scheme, func(_ string, opts storage.Options) (storage.Storage, error) {
+		return NewInMemory(opts.Generator), nil
+	})
+}
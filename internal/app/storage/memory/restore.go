@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (im *InMemory) BatchRestore(_ context.Context, data []string, user string) ([]string, error) {
+	im.lock.Lock()
+	defer im.lock.Unlock()
+
+	now := time.Now()
+	restored := make([]string, 0, len(data))
+	for _, id := range data {
+		rec, isExists := im.byID[id]
+		//Восстановить можно только свою удаленную и еще не истекшую ссылку
+		if !isExists || rec.User != user || !rec.IsDeleted || storage.IsExpired(rec.ExpiresAt, now) {
+			continue
+		}
+		rec.IsDeleted = false
+		rec.DeletedAt = time.Time{}
+		im.byID[id] = rec
+		restored = append(restored, id)
+	}
+	return restored, nil
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"time"
+)
+
+func (im *InMemory) Export(_ context.Context, f func(rec storage.Record) error) error {
+	//Записи передаются из снимка, чтобы f не выполнялась под блокировкой
+	im.lock.RLock()
+	snapshot := make([]storage.Record, 0, len(im.byID))
+	for id, rec := range im.byID {
+		snapshot = append(snapshot, storage.Record{
+			ShortID:     id,
+			OriginalURL: rec.OriginalURL,
+			User:        rec.User,
+			IsDeleted:   rec.IsDeleted,
+			DeletedAt:   rec.DeletedAt,
+			ExpiresAt:   rec.ExpiresAt,
+			MaxClicks:   rec.MaxClicks,
+			Clicks:      rec.Clicks,
+			History:     rec.History,
+		})
+	}
+	im.lock.RUnlock()
+
+	for _, rec := range snapshot {
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *InMemory) Import(_ context.Context, data []storage.Record) (int, error) {
+	im.lock.Lock()
+	defer im.lock.Unlock()
+
+	now := time.Now()
+	imported := 0
+	for _, rec := range data {
+		if _, isExists := im.findByURL(rec.OriginalURL, rec.User); isExists || !im.isFreeID(rec.ShortID) {
+			continue
+		}
+		if rec.IsDeleted && rec.DeletedAt.IsZero() {
+			rec.DeletedAt = now
+		}
+		im.put(rec.ShortID, Record{
+			OriginalURL: rec.OriginalURL,
+			User:        rec.User,
+			IsDeleted:   rec.IsDeleted,
+			DeletedAt:   rec.DeletedAt,
+			ExpiresAt:   rec.ExpiresAt,
+			MaxClicks:   rec.MaxClicks,
+			Clicks:      rec.Clicks,
+			History:     rec.History,
+		})
+		imported++
+	}
+	return imported, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// PurgeHook удаляет связанные с окончательно удаленными ссылками данные, например статистику переходов
+type PurgeHook func(ctx context.Context, ids []string) error
+
+// RunPurger периодически окончательно удаляет ссылки, удаленные более retention назад, пока не будет отменен ctx
+func RunPurger(ctx context.Context, store Purger, retention time.Duration, interval time.Duration, hook PurgeHook) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			PurgeDeleted(ctx, store, time.Now().Add(-retention), hook)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PurgeDeleted однократно удаляет ссылки, удаленные раньше before, и в том же проходе вызывает hook для их ID,
+// чтобы данные прежней ссылки не достались ID, выданному заново
+func PurgeDeleted(ctx context.Context, store Purger, before time.Time, hook PurgeHook) {
+	purged, err := store.PurgeDeleted(ctx, before)
+	if len(purged) > 0 && hook != nil {
+		if hookErr := hook(ctx, purged); hookErr != nil {
+			log.Error("Purge deleted URLs data error: ", hookErr)
+		}
+	}
+	if err != nil {
+		log.Error("Purge deleted URLs error: ", err)
+		return
+	}
+	if len(purged) > 0 {
+		log.Infof("Purged %d deleted URLs", len(purged))
+	}
+}
+
+// PurgeWrapped передает очистку хранилищу store, обернутому декоратором. Для хранилища без поддержки очистки - ошибка
+func PurgeWrapped(ctx context.Context, store Storage, before time.Time) ([]string, error) {
+	purger, isPurger := store.(Purger)
+	if !isPurger {
+		return nil, fmt.Errorf("storage %T does not support purge", store)
+	}
+	return purger.PurgeDeleted(ctx, before)
+}
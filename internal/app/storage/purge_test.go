@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+// purgeStub хранилище, которое окончательно удаляет заданные ID
+type purgeStub struct {
+	purged []string
+	err    error
+}
+
+func (ps *purgeStub) PurgeDeleted(_ context.Context, _ time.Time) ([]string, error) {
+	return ps.purged, ps.err
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	tests := []struct {
+		name  string
+		store *purgeStub
+		want  []string
+	}{
+		{
+			name:  "Test hook gets purged IDs",
+			store: &purgeStub{purged: []string{"abc", "def"}},
+			want:  []string{"abc", "def"},
+		},
+		{
+			name:  "Test hook skipped without purged IDs",
+			store: &purgeStub{purged: []string{}},
+		},
+		{
+			name:  "Test hook gets IDs purged before error",
+			store: &purgeStub{purged: []string{"abc"}, err: errors.New("purge error")},
+			want:  []string{"abc"},
+		},
+	}
+	for _, tt := range tests {
+		test := tt
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			PurgeDeleted(context.Background(), test.store, time.Now(), func(_ context.Context, ids []string) error {
+				got = append(got, ids...)
+				return nil
+			})
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// RunReaper периодически удаляет истекшие ссылки через BatchDelete, пока не будет отменен ctx
+func RunReaper(ctx context.Context, store Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ReapExpired(ctx, store, time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReapExpired однократно удаляет ссылки, истекшие к моменту now
+func ReapExpired(ctx context.Context, store Storage, now time.Time) {
+	expired, err := store.GetExpired(ctx, now)
+	if err != nil {
+		log.Error("Get expired URLs error: ", err)
+		return
+	}
+	for user, ids := range expired {
+		store.BatchDelete(ctx, ids, user)
+	}
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownScheme говорит о том что для схемы DSN не зарегистрировано хранилище
+var ErrUnknownScheme = errors.New("unknown storage scheme")
+
+// Options общие параметры создания хранилища
+type Options struct {
+	//Generator генератор ID сокращенных ссылок
+	Generator idgen.IDGenerator
+	//ReadOnly открыть существующее хранилище без изменений, например как источник переноса.
+	//Учитывается хранилищами на локальных файлах, файл не создается и не восстанавливается
+	ReadOnly bool
+}
+
+// Factory создает хранилище по DSN, параметры хранилища передаются в самом DSN
+type Factory func(dsn string, opts Options) (Storage, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Factory)
+)
+
+// Register регистрирует хранилище для схемы DSN, вызывается из init пакета хранилища
+func Register(scheme string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if _, isExists := registry[scheme]; isExists {
+		panic("storage: scheme " + scheme + " is registered twice")
+	}
+	registry[scheme] = factory
+}
+
+// Schemes возвращает отсортированный список зарегистрированных схем
+func Schemes() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	result := make([]string, 0, len(registry))
+	for scheme := range registry {
+		result = append(result, scheme)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Open создает хранилище, выбранное по схеме DSN
+func Open(dsn string, opts Options) (Storage, error) {
+	scheme := Scheme(dsn)
+	registryLock.RLock()
+	factory, isExists := registry[scheme]
+	registryLock.RUnlock()
+	if !isExists {
+		return nil, fmt.Errorf("%w %q, known schemes: %s", ErrUnknownScheme, scheme, strings.Join(Schemes(), ", "))
+	}
+	return factory(dsn, opts)
+}
+
+// Scheme возвращает схему DSN в нижнем регистре.
+// DSN без схемы вида "host=localhost user=app" считается DSN Postgres в формате ключ=значение
+func Scheme(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err == nil && u.Scheme != "" {
+		return strings.ToLower(u.Scheme)
+	}
+	if strings.Contains(dsn, "=") {
+		return "postgres"
+	}
+	return ""
+}
+
+// FilePath возвращает путь к файлу из DSN вида file:///abs/path, file://rel/path или file:rel/path
+func FilePath(u *url.URL) (string, error) {
+	if u.Opaque != "" {
+		return url.PathUnescape(u.Opaque)
+	}
+	if u.Host+u.Path == "" {
+		return "", fmt.Errorf("storage %s: empty file path", u.Scheme)
+	}
+	return u.Host + u.Path, nil
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"errors"
+	"github.com/olkonon/shortener/internal/app/common"
+	"time"
 )
 
 // ErrDuplicateURL говорит о том что пытаются добавить уже существующий URL
@@ -10,33 +12,179 @@ var ErrDuplicateURL = errors.New("duplicate! URL is exists")
 var ErrUserURLListEmpty = errors.New("user no URL")
 var ErrDeletedURL = errors.New("url is deleted")
 
+// ErrNotFound говорит о том что сокращенной ссылки с таким ID нет
+var ErrNotFound = errors.New("unknown id")
+
+// ErrExpiredURL говорит о том что срок жизни ссылки истек
+var ErrExpiredURL = errors.New("url is expired")
+
+// ErrClicksExhausted говорит о том что лимит переходов по ссылке исчерпан
+var ErrClicksExhausted = errors.New("url clicks limit is exhausted")
+
+// ErrAliasExists говорит о том что запрошенный псевдоним уже занят
+var ErrAliasExists = errors.New("alias is exists")
+
+// ErrURLTooLong говорит о том что URL длиннее MaxURLLength
+var ErrURLTooLong = errors.New("url is too long")
+
+// ErrReadOnly говорит о том что хранилище открыто только для чтения
+var ErrReadOnly = errors.New("storage is opened read-only")
+
+// MaxURLLength максимальная длина URL, одинаковая для всех хранилищ
+const MaxURLLength = common.MaxURLLength
+
 // Storage интерфейс для хранилища данных
 type Storage interface {
-	//GenIDByURL генерирует ID сокращенной ссылки из полученного URL
-	GenIDByURL(ctx context.Context, url string, user string) (string, error)
-	//GetURLByID возвращает URL соответствующий ID сокращенной ссылки
+	//GenIDByURL генерирует ID сокращенной ссылки из полученного URL, либо резервирует opts.Alias если он задан.
+	//Для действующей ссылки пользователя на url - прежний ID и ErrDuplicateURL, удаленная или истекшая выдается заново под прежним ID
+	GenIDByURL(ctx context.Context, url string, user string, opts SaveOptions) (string, error)
+	//GetURLByID возвращает URL соответствующий ID сокращенной ссылки, для ссылок с лимитом переходов атомарно учитывает переход
 	GetURLByID(ctx context.Context, id string) (string, error)
+	//GetLink возвращает параметры ссылки без учета перехода, для неизвестного ID - ErrNotFound, для удаленной - ErrDeletedURL.
+	//Срок жизни и лимит переходов не проверяются
+	GetLink(ctx context.Context, id string) (Link, error)
 	//GetByUser возвращает все сохраненные URL для пользователя
 	GetByUser(ctx context.Context, user string) ([]UserRecord, error)
-	//BatchSave сохраняет пачку запросов
+	//BatchSave атомарно сохраняет пачку запросов, для каждого элемента возвращает ID и SaveStatus.
+	//Повтор URL внутри пачки получает тот же ID со статусом SaveExisted
 	BatchSave(ctx context.Context, data []BatchSaveRequest, user string) ([]BatchSaveResponse, error)
 	//BatchDelete асинхронно удаляет пачку url у пользователя
 	BatchDelete(ctx context.Context, data []string, user string)
+	//UpdateURL меняет адрес ссылки пользователя с сохранением ID, прежний адрес добавляется в историю.
+	//Для неизвестной или чужой ссылки - ErrNotFound, для удаленной - ErrDeletedURL,
+	//если у пользователя уже есть другая ссылка на url - ErrDuplicateURL
+	UpdateURL(ctx context.Context, id string, user string, url string) error
+	//GetHistory возвращает прежние адреса ссылки пользователя в порядке замены, для неизвестной или чужой ссылки - ErrNotFound
+	GetHistory(ctx context.Context, id string, user string) ([]HistoryRecord, error)
+	//BatchRestore восстанавливает удаленные ссылки пользователя и возвращает ID восстановленных.
+	//Чужие, не удаленные, истекшие и окончательно удаленные ссылки пропускаются
+	BatchRestore(ctx context.Context, data []string, user string) ([]string, error)
+	//GetExpired возвращает ID истекших к моменту now и еще не удаленных ссылок, сгруппированные по пользователю
+	GetExpired(ctx context.Context, now time.Time) (map[string][]string, error)
 	//Close корректно завершает работу любого Storage
 	Close() error
 }
 
+// SaveOptions дополнительные параметры создания сокращенной ссылки
+type SaveOptions struct {
+	//Alias желаемый ID сокращенной ссылки, если пустой ID генерируется
+	Alias string
+	//ExpiresAt время истечения ссылки, нулевое значение - ссылка бессрочная
+	ExpiresAt time.Time
+	//MaxClicks максимальное количество переходов по ссылке, 0 - без ограничений
+	MaxClicks int
+}
+
+// Link параметры сокращенной ссылки
+type Link struct {
+	OriginalURL string
+	//ExpiresAt время истечения ссылки, нулевое значение - ссылка бессрочная
+	ExpiresAt time.Time
+	//MaxClicks максимальное количество переходов по ссылке, 0 - без ограничений
+	MaxClicks int
+}
+
+// HistoryRecord прежний адрес сокращенной ссылки
+type HistoryRecord struct {
+	URL string
+	//ChangedAt время замены адреса
+	ChangedAt time.Time
+}
+
+// AppendHistory возвращает копию истории с добавленным адресом, исходный срез не изменяется
+func AppendHistory(history []HistoryRecord, url string, changedAt time.Time) []HistoryRecord {
+	result := make([]HistoryRecord, len(history), len(history)+1)
+	copy(result, history)
+	return append(result, HistoryRecord{URL: url, ChangedAt: changedAt})
+}
+
+// Compactor хранилище с журналом, поддерживающее сжатие по запросу
+type Compactor interface {
+	//Compact переписывает актуальное состояние хранилища, удаляя устаревшие записи журнала
+	Compact() error
+}
+
+// Purger хранилище, поддерживающее окончательное удаление ранее удаленных ссылок
+type Purger interface {
+	//PurgeDeleted окончательно удаляет ссылки, удаленные раньше before, и возвращает их ID.
+	//После этого ID ссылки неизвестен хранилищу и может быть выдан снова
+	PurgeDeleted(ctx context.Context, before time.Time) ([]string, error)
+}
+
 type BatchSaveRequest struct {
 	CorrelationID string
 	OriginalURL   string
+	ExpiresAt     time.Time
+	MaxClicks     int
 }
 
 type BatchSaveResponse struct {
 	CorrelationID string
 	ShortID       string
+	Status        SaveStatus
 }
 
+// SaveStatus результат сохранения элемента пачки
+type SaveStatus string
+
+const (
+	// SaveCreated создана новая сокращенная ссылка
+	SaveCreated SaveStatus = "created"
+	// SaveExisted пользователь уже сохранял этот URL, возвращен прежний ID без изменений
+	SaveExisted SaveStatus = "existed"
+	// SaveRestored ранее удаленная или истекшая ссылка восстановлена с прежним ID и параметрами из запроса
+	SaveRestored SaveStatus = "restored"
+)
+
 type UserRecord struct {
 	OriginalURL string
 	ShortID     string
 }
+
+// IsExpired проверяет истек ли к моменту now срок жизни ссылки, нулевой expiresAt означает бессрочную ссылку
+func IsExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// IsRenewable проверяет что ссылку можно выдать заново при повторном сокращении того же URL:
+// она удалена или срок ее жизни истек
+func IsRenewable(isDeleted bool, expiresAt time.Time, now time.Time) bool {
+	return isDeleted || IsExpired(expiresAt, now)
+}
+
+// CheckURL проверяет что URL можно сохранить в хранилище
+func CheckURL(url string) error {
+	if len(url) > MaxURLLength {
+		return ErrURLTooLong
+	}
+	return nil
+}
+
+// CheckBatch проверяет что все URL пачки можно сохранить в хранилище
+func CheckBatch(data []BatchSaveRequest) error {
+	for _, val := range data {
+		if err := CheckURL(val.OriginalURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsExpected проверяет что ошибка - штатный ответ хранилища вроде ErrNotFound, а не сбой
+func IsExpected(err error) bool {
+	for _, expected := range []error{
+		ErrNotFound,
+		ErrDuplicateURL,
+		ErrUserURLListEmpty,
+		ErrDeletedURL,
+		ErrExpiredURL,
+		ErrClicksExhausted,
+		ErrAliasExists,
+		ErrURLTooLong,
+	} {
+		if errors.Is(err, expected) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrency количество параллельных запросов в проверках конкурентного доступа
+const concurrency = 20
+
+// Run проверяет контракт storage.Storage. Атомарность пачек проверяет RunBatchAtomicity, хранилища,
+// реализующие storage.Importer и storage.Purger, дополнительно вызывают RunTransfer и RunPurge
+func Run(t *testing.T, newStore Factory) {
+	t.Run("GenIDByURL", func(t *testing.T) { RunGenID(t, newStore) })
+	t.Run("BatchSave", func(t *testing.T) { RunBatchSave(t, newStore) })
+	t.Run("BatchDelete", func(t *testing.T) { RunDelete(t, newStore) })
+	t.Run("GetByUser", func(t *testing.T) { RunUserList(t, newStore) })
+	t.Run("Limits", func(t *testing.T) { RunLimits(t, newStore) })
+	t.Run("Concurrency", func(t *testing.T) { RunConcurrency(t, newStore) })
+	t.Run("Restore", func(t *testing.T) { RunRestore(t, newStore) })
+	t.Run("History", func(t *testing.T) { RunHistory(t, newStore) })
+}
+
+// RunGenID проверяет сохранение одиночной ссылки, дубликаты и псевдонимы
+func RunGenID(t *testing.T, newStore Factory) {
+	t.Run("duplicate url", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+
+		existsID, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+		assert.Equal(t, id, existsID)
+
+		//Тот же URL другого пользователя - отдельная ссылка
+		otherID, err := store.GenIDByURL(context.Background(), "https://test.com", otherUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		assert.NotEqual(t, id, otherID)
+	})
+
+	t.Run("renew deleted", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+
+		//Удаленная ссылка выдается заново под прежним ID с параметрами из запроса
+		renewedID, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{MaxClicks: 1})
+		require.NoError(t, err)
+		assert.Equal(t, id, renewedID)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+	})
+
+	t.Run("renew expired", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{
+			ExpiresAt: time.Now().Add(200 * time.Millisecond),
+		})
+		require.NoError(t, err)
+		time.Sleep(200 * time.Millisecond)
+		_, err = store.GetURLByID(context.Background(), id)
+		require.ErrorIs(t, err, storage.ErrExpiredURL)
+
+		renewedID, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, id, renewedID)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+		expired, err := store.GetExpired(context.Background(), time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, expired)
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{Alias: "conformance-alias"})
+		require.NoError(t, err)
+		assert.Equal(t, "conformance-alias", id)
+
+		//Псевдоним уникален среди всех пользователей
+		_, err = store.GenIDByURL(context.Background(), "https://test2.com", otherUser, storage.SaveOptions{Alias: "conformance-alias"})
+		assert.ErrorIs(t, err, storage.ErrAliasExists)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+	})
+
+	t.Run("url too long", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.GenIDByURL(context.Background(), "https://test.com/"+strings.Repeat("a", storage.MaxURLLength),
+			testUser, storage.SaveOptions{})
+		assert.ErrorIs(t, err, storage.ErrURLTooLong)
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.GetURLByID(context.Background(), "unknown-id")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = store.GetLink(context.Background(), "unknown-id")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+// failURL адрес, для которого failingGen не выдает ID
+const failURL = "https://fail.com"
+
+// failingGen генератор, который не выдает допустимых ID для failURL, имитирует сбой после проверки пачки
+type failingGen struct {
+	gen idgen.IDGenerator
+}
+
+func (fg failingGen) Candidate(url string, attempt int) string {
+	if url == failURL {
+		return ""
+	}
+	return fg.gen.Candidate(url, attempt)
+}
+
+// RunBatchAtomicity проверяет что пачка с некорректным элементом или со сбоем при сохранении не сохраняется частично
+func RunBatchAtomicity(t *testing.T, newStore GenFactory) {
+	t.Run("url too long in batch", func(t *testing.T) {
+		store := newStore(t, idgen.NewHash(common.GenHashedString))
+		_, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+			{CorrelationID: "2", OriginalURL: "https://test.com/" + strings.Repeat("a", storage.MaxURLLength)},
+		}, testUser)
+		assert.ErrorIs(t, err, storage.ErrURLTooLong)
+
+		_, err = store.GetByUser(context.Background(), testUser)
+		assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+	})
+
+	t.Run("generate error in batch", func(t *testing.T) {
+		store := newStore(t, failingGen{gen: idgen.NewHash(common.GenHashedString)})
+		deletedID, err := store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{deletedID}, testUser)
+		WaitDeleted(t, store, deletedID)
+
+		//Сбой на последнем элементе, новая ссылка и восстановление удаленной не должны сохраниться
+		_, err = store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+			{CorrelationID: "2", OriginalURL: "https://test2.com"},
+			{CorrelationID: "3", OriginalURL: failURL},
+		}, testUser)
+		assert.ErrorIs(t, err, idgen.ErrGenerateID)
+
+		_, err = store.GetByUser(context.Background(), testUser)
+		assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+		_, err = store.GetLink(context.Background(), deletedID)
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	})
+}
+
+// RunDelete проверяет асинхронное удаление ссылок владельцем
+func RunDelete(t *testing.T, newStore Factory) {
+	t.Run("delete own", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		aliveID, err := store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+
+		store.BatchDelete(context.Background(), []string{id, "unknown-id"}, testUser)
+		WaitDeleted(t, store, id)
+		_, err = store.GetLink(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+		got, err := store.GetURLByID(context.Background(), aliveID)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test2.com", got)
+
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test2.com", ShortID: aliveID}}, list)
+	})
+
+	t.Run("delete other user link", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		otherID, err := store.GenIDByURL(context.Background(), "https://test.com", otherUser, storage.SaveOptions{})
+		require.NoError(t, err)
+
+		//Удаление чужой ссылки пропускается, своя ссылка в той же пачке удаляется
+		store.BatchDelete(context.Background(), []string{id, otherID}, otherUser)
+		WaitDeleted(t, store, otherID)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+	})
+}
+
+// RunUserList проверяет список ссылок пользователя
+func RunUserList(t *testing.T, newStore Factory) {
+	t.Run("per user", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.GetByUser(context.Background(), testUser)
+		assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+
+		id1, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		id2, err := store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		otherID, err := store.GenIDByURL(context.Background(), "https://test3.com", otherUser, storage.SaveOptions{})
+		require.NoError(t, err)
+
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []storage.UserRecord{
+			{OriginalURL: "https://test.com", ShortID: id1},
+			{OriginalURL: "https://test2.com", ShortID: id2},
+		}, list)
+		list, err = store.GetByUser(context.Background(), otherUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test3.com", ShortID: otherID}}, list)
+	})
+
+	t.Run("all deleted", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+
+		_, err = store.GetByUser(context.Background(), testUser)
+		assert.ErrorIs(t, err, storage.ErrUserURLListEmpty)
+	})
+}
+
+// RunLimits проверяет срок жизни и лимит переходов
+func RunLimits(t *testing.T, newStore Factory) {
+	t.Run("expiration", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{
+			ExpiresAt: time.Now().Add(200 * time.Millisecond),
+		})
+		require.NoError(t, err)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+
+		time.Sleep(200 * time.Millisecond)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrExpiredURL)
+		expired, err := store.GetExpired(context.Background(), time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{testUser: {id}}, expired)
+	})
+
+	t.Run("max clicks", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{MaxClicks: 2})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			got, err := store.GetURLByID(context.Background(), id)
+			require.NoError(t, err)
+			assert.Equal(t, "https://test.com", got)
+		}
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+		link, err := store.GetLink(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, 2, link.MaxClicks)
+	})
+}
+
+// RunConcurrency проверяет корректность хранилища при параллельных запросах
+func RunConcurrency(t *testing.T, newStore Factory) {
+	t.Run("same url", func(t *testing.T) {
+		store := newStore(t)
+		ids := make([]string, concurrency)
+		errs := make([]error, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ids[i], errs[i] = store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+			}(i)
+		}
+		wg.Wait()
+
+		//Ссылка создается один раз, остальные запросы получают тот же ID
+		created := 0
+		for i, err := range errs {
+			if err == nil {
+				created++
+			} else {
+				assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+			}
+			assert.Equal(t, ids[0], ids[i])
+		}
+		assert.Equal(t, 1, created)
+	})
+
+	t.Run("different urls", func(t *testing.T) {
+		store := newStore(t)
+		ids := make([]string, concurrency)
+		errs := make([]error, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ids[i], errs[i] = store.GenIDByURL(context.Background(), fmt.Sprintf("https://test.com/%d", i), testUser, storage.SaveOptions{})
+			}(i)
+		}
+		wg.Wait()
+
+		unique := make(map[string]bool)
+		for i, err := range errs {
+			require.NoError(t, err)
+			unique[ids[i]] = true
+		}
+		assert.Len(t, unique, concurrency)
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.Len(t, list, concurrency)
+	})
+
+	t.Run("clicks limit", func(t *testing.T) {
+		store := newStore(t)
+		const maxClicks = 5
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{MaxClicks: maxClicks})
+		require.NoError(t, err)
+
+		errs := make([]error, concurrency)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = store.GetURLByID(context.Background(), id)
+			}(i)
+		}
+		wg.Wait()
+
+		//Переходов ровно столько, сколько разрешено лимитом
+		clicks := 0
+		for _, err := range errs {
+			if err == nil {
+				clicks++
+			} else if !errors.Is(err, storage.ErrClicksExhausted) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		assert.Equal(t, maxClicks, clicks)
+	})
+}
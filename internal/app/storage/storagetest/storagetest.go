@@ -0,0 +1,354 @@
+// Package storagetest общие тесты соответствия поведения реализаций storage.Storage
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Factory создает пустое хранилище для теста, освобождение ресурсов регистрируется через t.Cleanup
+type Factory func(t *testing.T) storage.Storage
+
+// GenFactory создает пустое хранилище для теста с генератором ID gen
+type GenFactory func(t *testing.T, gen idgen.IDGenerator) storage.Storage
+
+const (
+	testUser  = "conformance-user"
+	otherUser = "conformance-other-user"
+)
+
+// RunBatchSave проверяет единую семантику дубликатов в BatchSave
+func RunBatchSave(t *testing.T, newStore Factory) {
+	t.Run("created then existed", func(t *testing.T) {
+		store := newStore(t)
+		request := []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+			{CorrelationID: "2", OriginalURL: "https://test2.com"},
+		}
+		first, err := store.BatchSave(context.Background(), request, testUser)
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+		assert.NotEqual(t, first[0].ShortID, first[1].ShortID)
+		for i, val := range first {
+			assert.Equal(t, request[i].CorrelationID, val.CorrelationID)
+			assert.Equal(t, storage.SaveCreated, val.Status)
+			got, err := store.GetURLByID(context.Background(), val.ShortID)
+			require.NoError(t, err)
+			assert.Equal(t, request[i].OriginalURL, got)
+		}
+
+		second, err := store.BatchSave(context.Background(), request, testUser)
+		require.NoError(t, err)
+		for i, val := range second {
+			assert.Equal(t, first[i].ShortID, val.ShortID)
+			assert.Equal(t, storage.SaveExisted, val.Status)
+		}
+	})
+
+	t.Run("duplicate inside batch", func(t *testing.T) {
+		store := newStore(t)
+		res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+			{CorrelationID: "2", OriginalURL: "https://test.com"},
+		}, testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.BatchSaveResponse{
+			{CorrelationID: "1", ShortID: res[0].ShortID, Status: storage.SaveCreated},
+			{CorrelationID: "2", ShortID: res[0].ShortID, Status: storage.SaveExisted},
+		}, res)
+	})
+
+	t.Run("restored after delete", func(t *testing.T) {
+		store := newStore(t)
+		res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+		}, testUser)
+		require.NoError(t, err)
+		id := res[0].ShortID
+
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+
+		res, err = store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com", MaxClicks: 1},
+		}, testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.BatchSaveResponse{
+			{CorrelationID: "1", ShortID: id, Status: storage.SaveRestored},
+		}, res)
+
+		//Восстановленная ссылка получает параметры из запроса
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+	})
+
+	t.Run("restored after expiration", func(t *testing.T) {
+		store := newStore(t)
+		res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com", ExpiresAt: time.Now().Add(200 * time.Millisecond)},
+		}, testUser)
+		require.NoError(t, err)
+		id := res[0].ShortID
+		time.Sleep(200 * time.Millisecond)
+
+		res, err = store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+		}, testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.BatchSaveResponse{
+			{CorrelationID: "1", ShortID: id, Status: storage.SaveRestored},
+		}, res)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+	})
+
+	t.Run("same URL for other user", func(t *testing.T) {
+		store := newStore(t)
+		res, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+		}, testUser)
+		require.NoError(t, err)
+		other, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+		}, otherUser)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SaveCreated, other[0].Status)
+		assert.NotEqual(t, res[0].ShortID, other[0].ShortID)
+	})
+}
+
+// WaitDeleted ожидает применения асинхронного BatchDelete
+func WaitDeleted(t *testing.T, store storage.Storage, id string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		_, err := store.GetURLByID(context.Background(), id)
+		return errors.Is(err, storage.ErrDeletedURL)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// RunTransfer проверяет загрузку и выгрузку записей с сохранением ID для хранилищ,
+// реализующих storage.Exporter и storage.Importer
+func RunTransfer(t *testing.T, newStore Factory) {
+	t.Run("import and export", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		records := []storage.Record{
+			{ShortID: "transfer-1", OriginalURL: "https://test.com", User: testUser, History: []storage.HistoryRecord{
+				{URL: "https://old.com", ChangedAt: expiresAt.Add(-3 * time.Hour)},
+			}},
+			{ShortID: "transfer-2", OriginalURL: "https://test2.com", User: testUser, IsDeleted: true, DeletedAt: expiresAt.Add(-2 * time.Hour)},
+			{ShortID: "transfer-3", OriginalURL: "https://test.com", User: otherUser, ExpiresAt: expiresAt, MaxClicks: 5, Clicks: 2},
+		}
+
+		store := newStore(t)
+		importer, ok := store.(storage.Importer)
+		require.True(t, ok, "storage must implement storage.Importer")
+		exporter, ok := store.(storage.Exporter)
+		require.True(t, ok, "storage must implement storage.Exporter")
+
+		imported, err := importer.Import(context.Background(), records)
+		require.NoError(t, err)
+		assert.Equal(t, len(records), imported)
+
+		got, err := store.GetURLByID(context.Background(), "transfer-1")
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+		_, err = store.GetURLByID(context.Background(), "transfer-2")
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+
+		//Занятый ID и занятая пара пользователь и URL пропускаются
+		imported, err = importer.Import(context.Background(), []storage.Record{
+			{ShortID: "transfer-1", OriginalURL: "https://other.com", User: otherUser},
+			{ShortID: "transfer-4", OriginalURL: "https://test.com", User: testUser},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, imported)
+
+		exported := make(map[string]storage.Record)
+		err = exporter.Export(context.Background(), func(rec storage.Record) error {
+			rec.ExpiresAt = rec.ExpiresAt.UTC()
+			rec.DeletedAt = rec.DeletedAt.UTC()
+			for i := range rec.History {
+				rec.History[i].ChangedAt = rec.History[i].ChangedAt.UTC()
+			}
+			exported[rec.ShortID] = rec
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, exported, len(records))
+		for _, rec := range records {
+			assert.Equal(t, rec, exported[rec.ShortID])
+		}
+	})
+}
+
+// RunPurge проверяет окончательное удаление для хранилищ, реализующих storage.Purger
+func RunPurge(t *testing.T, newStore Factory) {
+	t.Run("purge deleted", func(t *testing.T) {
+		store := newStore(t)
+		purger, ok := store.(storage.Purger)
+		require.True(t, ok, "storage must implement storage.Purger")
+
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		aliveID, err := store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+
+		//Срок хранения еще не прошел
+		purged, err := purger.PurgeDeleted(context.Background(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, purged)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrDeletedURL)
+
+		purged, err = purger.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, purged)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		got, err := store.GetURLByID(context.Background(), aliveID)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test2.com", got)
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test2.com", ShortID: aliveID}}, list)
+
+		//URL после окончательного удаления сохраняется как новый
+		result, err := store.BatchSave(context.Background(), []storage.BatchSaveRequest{
+			{CorrelationID: "1", OriginalURL: "https://test.com"},
+		}, testUser)
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		assert.Equal(t, storage.SaveCreated, result[0].Status)
+	})
+
+	t.Run("purged is not restored", func(t *testing.T) {
+		store := newStore(t)
+		purger, ok := store.(storage.Purger)
+		require.True(t, ok, "storage must implement storage.Purger")
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+		_, err = purger.PurgeDeleted(context.Background(), time.Now().Add(time.Second))
+		require.NoError(t, err)
+
+		restored, err := store.BatchRestore(context.Background(), []string{id}, testUser)
+		require.NoError(t, err)
+		assert.Empty(t, restored)
+		_, err = store.GetURLByID(context.Background(), id)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+// RunRestore проверяет восстановление удаленных ссылок
+func RunRestore(t *testing.T, newStore Factory) {
+	t.Run("restore deleted", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		aliveID, err := store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+
+		//Чужую ссылку восстановить нельзя
+		restored, err := store.BatchRestore(context.Background(), []string{id}, otherUser)
+		require.NoError(t, err)
+		assert.Empty(t, restored)
+
+		restored, err = store.BatchRestore(context.Background(), []string{id, aliveID, "unknown-id", id}, testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, restored)
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test.com", got)
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.Len(t, list, 2)
+	})
+
+	t.Run("expired is not restored", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{
+			ExpiresAt: time.Now().Add(200 * time.Millisecond),
+		})
+		require.NoError(t, err)
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+		time.Sleep(200 * time.Millisecond)
+
+		restored, err := store.BatchRestore(context.Background(), []string{id}, testUser)
+		require.NoError(t, err)
+		assert.Empty(t, restored)
+	})
+
+}
+
+// RunHistory проверяет изменение адреса ссылки и историю прежних адресов
+func RunHistory(t *testing.T, newStore Factory) {
+	t.Run("update url", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		history, err := store.GetHistory(context.Background(), id, testUser)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		require.NoError(t, store.UpdateURL(context.Background(), id, testUser, "https://test2.com"))
+		require.NoError(t, store.UpdateURL(context.Background(), id, testUser, "https://test3.com"))
+		got, err := store.GetURLByID(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "https://test3.com", got)
+
+		history, err = store.GetHistory(context.Background(), id, testUser)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, "https://test.com", history[0].URL)
+		assert.Equal(t, "https://test2.com", history[1].URL)
+		assert.False(t, history[1].ChangedAt.Before(history[0].ChangedAt))
+
+		//Прежний адрес освобождается и может быть сохранен заново
+		list, err := store.GetByUser(context.Background(), testUser)
+		require.NoError(t, err)
+		assert.Equal(t, []storage.UserRecord{{OriginalURL: "https://test3.com", ShortID: id}}, list)
+		newID, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		assert.NotEqual(t, id, newID)
+	})
+
+	t.Run("update errors", func(t *testing.T) {
+		store := newStore(t)
+		id, err := store.GenIDByURL(context.Background(), "https://test.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+		_, err = store.GenIDByURL(context.Background(), "https://test2.com", testUser, storage.SaveOptions{})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, store.UpdateURL(context.Background(), "unknown-id", testUser, "https://test3.com"), storage.ErrNotFound)
+		//Чужую ссылку изменить нельзя
+		assert.ErrorIs(t, store.UpdateURL(context.Background(), id, otherUser, "https://test3.com"), storage.ErrNotFound)
+		_, err = store.GetHistory(context.Background(), id, otherUser)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.ErrorIs(t, store.UpdateURL(context.Background(), id, testUser, "https://test2.com"), storage.ErrDuplicateURL)
+		//Тот же адрес не меняет историю
+		require.NoError(t, store.UpdateURL(context.Background(), id, testUser, "https://test.com"))
+		history, err := store.GetHistory(context.Background(), id, testUser)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		store.BatchDelete(context.Background(), []string{id}, testUser)
+		WaitDeleted(t, store, id)
+		assert.ErrorIs(t, store.UpdateURL(context.Background(), id, testUser, "https://test3.com"), storage.ErrDeletedURL)
+	})
+}
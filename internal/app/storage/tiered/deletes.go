@@ -0,0 +1,153 @@
+package tiered
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+)
+
+// deletesSuffix суффикс файла журнала удалений рядом с резервным журналом
+const deletesSuffix = ".deletes"
+
+// pendingDelete строка журнала удалений
+type pendingDelete struct {
+	ID   string
+	User string
+}
+
+// deleteJournal журнал удалений, которые основное хранилище может потерять при сбое, так как удаляет асинхронно.
+// Удаление остается в журнале, пока основное хранилище его не подтвердит
+type deleteJournal struct {
+	path string
+	lock sync.Mutex
+	//pending ID -> пользователь, запросивший удаление
+	pending map[string]string
+}
+
+// openDeleteJournal загружает журнал удалений, недописанные и поврежденные строки пропускаются
+func openDeleteJournal(path string) (*deleteJournal, error) {
+	dj := &deleteJournal{
+		path:    path,
+		pending: make(map[string]string),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var rec pendingDelete
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.ID == "" {
+			log.Warnf("Fallback delete journal %s: skip corrupt line %q", path, scanner.Text())
+			continue
+		}
+		dj.pending[rec.ID] = rec.User
+	}
+	return dj, scanner.Err()
+}
+
+// add дописывает удаления в журнал и сохраняет их на диск
+func (dj *deleteJournal) add(ids []string, user string) error {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		data, err := json.Marshal(pendingDelete{ID: id, User: user})
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(dj.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		dj.pending[id] = user
+	}
+	return nil
+}
+
+// snapshot возвращает копию ожидающих подтверждения удалений
+func (dj *deleteJournal) snapshot() map[string]string {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+
+	result := make(map[string]string, len(dj.pending))
+	for id, user := range dj.pending {
+		result[id] = user
+	}
+	return result
+}
+
+// forget отменяет удаления пользователя, если после них он снова сохранил или восстановил ссылки
+func (dj *deleteJournal) forget(ids []string, user string) error {
+	dj.lock.Lock()
+	owned := make([]string, 0)
+	for _, id := range ids {
+		if pendingUser, isExists := dj.pending[id]; isExists && pendingUser == user {
+			owned = append(owned, id)
+		}
+	}
+	dj.lock.Unlock()
+	return dj.remove(owned...)
+}
+
+// remove убирает удаления из журнала и атомарно переписывает файл
+func (dj *deleteJournal) remove(ids ...string) error {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+
+	removed := false
+	for _, id := range ids {
+		if _, isExists := dj.pending[id]; isExists {
+			delete(dj.pending, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for id, user := range dj.pending {
+		data, err := json.Marshal(pendingDelete{ID: id, User: user})
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	tmpPath := dj.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dj.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package tiered
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// staleCache LRU последних успешных переходов, используется только при недоступности основного хранилища.
+// Ссылки с лимитом переходов в него не попадают, так как переход по ним должно учесть основное хранилище
+type staleCache struct {
+	size  int
+	lock  sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type staleEntry struct {
+	id        string
+	url       string
+	expiresAt time.Time
+}
+
+func newStaleCache(size int) *staleCache {
+	return &staleCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (sc *staleCache) get(id string) (staleEntry, bool) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	elem, isExists := sc.items[id]
+	if !isExists {
+		return staleEntry{}, false
+	}
+	sc.order.MoveToFront(elem)
+	return *elem.Value.(*staleEntry), true
+}
+
+func (sc *staleCache) put(id string, url string, expiresAt time.Time) {
+	if sc.size <= 0 {
+		return
+	}
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	if elem, isExists := sc.items[id]; isExists {
+		e := elem.Value.(*staleEntry)
+		e.url = url
+		e.expiresAt = expiresAt
+		sc.order.MoveToFront(elem)
+		return
+	}
+	sc.items[id] = sc.order.PushFront(&staleEntry{id: id, url: url, expiresAt: expiresAt})
+	for sc.order.Len() > sc.size {
+		elem := sc.order.Back()
+		sc.order.Remove(elem)
+		delete(sc.items, elem.Value.(*staleEntry).id)
+	}
+}
+
+func (sc *staleCache) remove(ids ...string) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for _, id := range ids {
+		if elem, isExists := sc.items[id]; isExists {
+			sc.order.Remove(elem)
+			delete(sc.items, id)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package tiered хранилище с резервным локальным журналом на время недоступности основного хранилища.
+// Записи, которые не удалось сохранить в основное хранилище, пишутся в файловый журнал и переносятся
+// в основное хранилище после его восстановления, переходы во время сбоя обслуживаются из журнала
+// и из кэша последних успешных переходов
+package tiered
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/file"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// New оборачивает primary резервным журналом fallback, кэшем на staleSize переходов
+// и запускает перенос журнала в primary раз в retryInterval.
+// ID в журнале должны выдаваться случайным генератором, иначе при переносе они совпадут с уже занятыми
+func New(primary storage.Storage, fallback *file.InFile, staleSize int, retryInterval time.Duration) *Tiered {
+	deletes, err := openDeleteJournal(fallback.Path() + deletesSuffix)
+	if err != nil {
+		//Данная ошибка фатальна, так как без журнала удаления во время сбоя будут потеряны
+		log.Fatal("Fallback delete journal error: ", err)
+	}
+	tmp := &Tiered{
+		primary:     primary,
+		fallback:    fallback,
+		deletes:     deletes,
+		stale:       newStaleCache(staleSize),
+		stopChan:    make(chan struct{}),
+		stoppedChan: make(chan struct{}),
+	}
+	go tmp.replayWorker(retryInterval)
+	return tmp
+}
+
+// Tiered хранилище с резервным журналом
+type Tiered struct {
+	primary  storage.Storage
+	fallback *file.InFile
+	//deletes удаления, еще не подтвержденные основным хранилищем
+	deletes *deleteJournal
+	stale   *staleCache
+	//replayLock не дает запускать перенос журнала параллельно
+	replayLock sync.Mutex
+	//conflicts ID записей журнала, которые нельзя перенести, о них сообщается один раз
+	conflicts   map[string]struct{}
+	stopChan    chan struct{}
+	stoppedChan chan struct{}
+}
+
+// isUnavailable проверяет что ошибка означает сбой основного хранилища, а не штатный ответ
+// или отмену запроса клиентом
+func isUnavailable(err error) bool {
+	return err != nil && !storage.IsExpected(err) && !errors.Is(err, context.Canceled)
+}
+
+func (t *Tiered) GenIDByURL(ctx context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	id, err := t.primary.GenIDByURL(ctx, url, user, opts)
+	if !isUnavailable(err) {
+		if id != "" {
+			t.forgetDeletes([]string{id}, user)
+		}
+		return id, err
+	}
+	log.Warn("Primary storage is unavailable, saving to fallback journal: ", err)
+	return t.fallback.GenIDByURL(ctx, url, user, opts)
+}
+
+func (t *Tiered) BatchSave(ctx context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	result, err := t.primary.BatchSave(ctx, data, user)
+	if !isUnavailable(err) {
+		ids := make([]string, 0, len(result))
+		for _, val := range result {
+			ids = append(ids, val.ShortID)
+		}
+		t.forgetDeletes(ids, user)
+		return result, err
+	}
+	log.Warn("Primary storage is unavailable, saving to fallback journal: ", err)
+	return t.fallback.BatchSave(ctx, data, user)
+}
+
+func (t *Tiered) GetURLByID(ctx context.Context, id string) (string, error) {
+	//Параметры ссылки читаются заранее, чтобы не запоминать для сбоя ссылки с лимитом переходов
+	link, err := t.primary.GetLink(ctx, id)
+	switch {
+	case err == nil && link.MaxClicks > 0:
+		//Переход по ссылке с лимитом учитывается основным хранилищем
+		t.stale.remove(id)
+		var url string
+		if url, err = t.primary.GetURLByID(ctx, id); !isUnavailable(err) {
+			return url, err
+		}
+	case err == nil && storage.IsExpired(link.ExpiresAt, time.Now()):
+		t.stale.remove(id)
+		return "", storage.ErrExpiredURL
+	case err == nil:
+		t.stale.put(id, link.OriginalURL, link.ExpiresAt)
+		return link.OriginalURL, nil
+	case errors.Is(err, storage.ErrNotFound):
+		//Ссылка могла быть создана во время сбоя и еще не перенесена
+		if url, fallbackErr := t.fallback.GetURLByID(ctx, id); !errors.Is(fallbackErr, storage.ErrNotFound) {
+			return url, fallbackErr
+		}
+		return "", err
+	case !isUnavailable(err):
+		t.stale.remove(id)
+		return "", err
+	}
+
+	if url, fallbackErr := t.fallback.GetURLByID(ctx, id); !errors.Is(fallbackErr, storage.ErrNotFound) {
+		return url, fallbackErr
+	}
+	if e, isExists := t.stale.get(id); isExists {
+		if storage.IsExpired(e.expiresAt, time.Now()) {
+			return "", storage.ErrExpiredURL
+		}
+		return e.url, nil
+	}
+	return "", err
+}
+
+func (t *Tiered) GetLink(ctx context.Context, id string) (storage.Link, error) {
+	link, err := t.primary.GetLink(ctx, id)
+	if err == nil && link.MaxClicks == 0 {
+		t.stale.put(id, link.OriginalURL, link.ExpiresAt)
+	} else if err == nil {
+		t.stale.remove(id)
+	}
+	if err == nil || (!errors.Is(err, storage.ErrNotFound) && !isUnavailable(err)) {
+		return link, err
+	}
+	if link, fallbackErr := t.fallback.GetLink(ctx, id); !errors.Is(fallbackErr, storage.ErrNotFound) {
+		return link, fallbackErr
+	}
+	if isUnavailable(err) {
+		if e, isExists := t.stale.get(id); isExists {
+			return storage.Link{OriginalURL: e.url, ExpiresAt: e.expiresAt}, nil
+		}
+	}
+	return link, err
+}
+
+func (t *Tiered) GetByUser(ctx context.Context, user string) ([]storage.UserRecord, error) {
+	result, err := t.primary.GetByUser(ctx, user)
+	if err != nil && !errors.Is(err, storage.ErrUserURLListEmpty) {
+		return result, err
+	}
+	//Ссылки, еще не перенесенные из журнала, тоже принадлежат пользователю
+	pending, fallbackErr := t.fallback.GetByUser(ctx, user)
+	if fallbackErr != nil {
+		return result, err
+	}
+	result = append(result, pending...)
+	if len(result) == 0 {
+		return result, storage.ErrUserURLListEmpty
+	}
+	return result, nil
+}
+
+func (t *Tiered) BatchDelete(ctx context.Context, data []string, user string) {
+	t.stale.remove(data...)
+	//Основное хранилище удаляет асинхронно и при сбое теряет удаление, поэтому оно повторяется из журнала
+	if err := t.deletes.add(data, user); err != nil {
+		log.Error("Fallback delete journal error: ", err)
+	}
+	t.fallback.BatchDelete(ctx, data, user)
+	t.primary.BatchDelete(ctx, data, user)
+}
+
+func (t *Tiered) UpdateURL(ctx context.Context, id string, user string, url string) error {
+	t.stale.remove(id)
+	err := t.primary.UpdateURL(ctx, id, user, url)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	//Ссылка могла быть создана во время сбоя и еще не перенесена
+	if fallbackErr := t.fallback.UpdateURL(ctx, id, user, url); !errors.Is(fallbackErr, storage.ErrNotFound) {
+		return fallbackErr
+	}
+	return err
+}
+
+func (t *Tiered) GetHistory(ctx context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	result, err := t.primary.GetHistory(ctx, id, user)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return result, err
+	}
+	if result, fallbackErr := t.fallback.GetHistory(ctx, id, user); !errors.Is(fallbackErr, storage.ErrNotFound) {
+		return result, fallbackErr
+	}
+	return result, err
+}
+
+func (t *Tiered) BatchRestore(ctx context.Context, data []string, user string) ([]string, error) {
+	t.forgetDeletes(data, user)
+	restored, err := t.primary.BatchRestore(ctx, data, user)
+	if err != nil {
+		return restored, err
+	}
+	//Ссылки, удаленные до переноса из журнала, восстанавливаются в журнале
+	pending, err := t.fallback.BatchRestore(ctx, data, user)
+	return append(restored, pending...), err
+}
+
+func (t *Tiered) GetExpired(ctx context.Context, now time.Time) (map[string][]string, error) {
+	result, err := t.primary.GetExpired(ctx, now)
+	if err != nil {
+		return result, err
+	}
+	pending, err := t.fallback.GetExpired(ctx, now)
+	if err != nil {
+		return result, err
+	}
+	for user, ids := range pending {
+		result[user] = append(result[user], ids...)
+	}
+	return result, nil
+}
+
+func (t *Tiered) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	purged, err := storage.PurgeWrapped(ctx, t.primary, before)
+	if err != nil {
+		return purged, err
+	}
+	//Удаленные во время сбоя ссылки очищаются и в журнале
+	pending, err := t.fallback.PurgeDeleted(ctx, before)
+	return append(purged, pending...), err
+}
+
+func (t *Tiered) Close() error {
+	close(t.stopChan)
+	<-t.stoppedChan
+	//Последняя попытка перенести журнал, не перенесенные записи останутся в файле до следующего запуска
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := t.Replay(ctx); err != nil {
+		log.Error("Fallback journal replay error: ", err)
+	}
+	return errors.Join(t.primary.Close(), t.fallback.Close())
+}
+
+// replayWorker периодически переносит журнал в основное хранилище
+func (t *Tiered) replayWorker(interval time.Duration) {
+	defer close(t.stoppedChan)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stopChan:
+			return
+		case <-ticker.C:
+			if err := t.Replay(context.Background()); err != nil {
+				log.Warn("Fallback journal replay error: ", err)
+			}
+		}
+	}
+}
+
+// Replay повторяет неподтвержденные удаления и переносит записи журнала в основное хранилище
+// через storage.Importer с теми же ID, счетчиком переходов
+// и историей и убирает перенесенные из журнала. Загрузка только добавляет записи, поэтому удаленные
+// или истекшие ссылки основного хранилища не восстанавливаются. Записи, конфликтующие с основным хранилищем
+// по URL или ID, остаются в журнале. Перенос прерывается при первом сбое основного хранилища,
+// повторный перенос записи безопасен
+func (t *Tiered) Replay(ctx context.Context) error {
+	t.replayLock.Lock()
+	defer t.replayLock.Unlock()
+
+	if err := t.replayDeletes(ctx); err != nil {
+		return err
+	}
+	records := make([]storage.Record, 0)
+	err := t.fallback.Export(ctx, func(rec storage.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	done := make([]string, 0, len(records))
+	conflicts := make(map[string]struct{})
+	var replayErr error
+	for _, rec := range records {
+		if rec.IsDeleted {
+			//Удаленная во время сбоя ссылка не переносится
+			done = append(done, rec.ShortID)
+			continue
+		}
+		imported, err := storage.ImportWrapped(ctx, t.primary, []storage.Record{rec})
+		if err == nil && imported == 0 {
+			//Запись пропущена: она перенесена при прошлой попытке, либо ее ID или URL уже заняты
+			var isMoved bool
+			if isMoved, err = t.isMoved(ctx, rec); err == nil && !isMoved {
+				//Пользователь уже получил этот ID, поэтому конфликтующая запись остается в журнале и обслуживается из него
+				conflicts[rec.ShortID] = struct{}{}
+				if _, isReported := t.conflicts[rec.ShortID]; !isReported {
+					log.Errorf("Fallback link %s of user %s is kept in journal: its ID or URL is already taken in primary storage",
+						rec.ShortID, rec.User)
+				}
+				continue
+			}
+		}
+		if err != nil {
+			replayErr = err
+			break
+		}
+		done = append(done, rec.ShortID)
+	}
+	if replayErr == nil {
+		t.conflicts = conflicts
+	}
+
+	if len(done) > 0 {
+		log.Infof("Fallback journal: %d of %d records moved to primary storage", len(done), len(records))
+		if err = t.fallback.Drop(done...); err != nil {
+			return errors.Join(replayErr, err)
+		}
+	}
+	return replayErr
+}
+
+// forgetDeletes отменяет еще не подтвержденные удаления ссылок, которые пользователь снова сохранил или восстановил
+func (t *Tiered) forgetDeletes(ids []string, user string) {
+	if err := t.deletes.forget(ids, user); err != nil {
+		log.Error("Fallback delete journal error: ", err)
+	}
+}
+
+// replayDeletes повторяет журналированные удаления, пока основное хранилище не подтвердит их
+func (t *Tiered) replayDeletes(ctx context.Context) error {
+	done := make([]string, 0)
+	retry := make(map[string][]string)
+	var replayErr error
+	for id, user := range t.deletes.snapshot() {
+		isLive, err := t.isLiveOwned(ctx, id, user)
+		if err != nil {
+			replayErr = err
+			break
+		}
+		if isLive {
+			retry[user] = append(retry[user], id)
+			continue
+		}
+		//Ссылка удалена, неизвестна или принадлежит другому пользователю
+		done = append(done, id)
+	}
+	for user, ids := range retry {
+		t.primary.BatchDelete(ctx, ids, user)
+	}
+	return errors.Join(replayErr, t.deletes.remove(done...))
+}
+
+// isLiveOwned проверяет что ссылка в основном хранилище не удалена и принадлежит пользователю
+func (t *Tiered) isLiveOwned(ctx context.Context, id string, user string) (bool, error) {
+	if _, err := t.primary.GetLink(ctx, id); err != nil {
+		return false, ignoreExpected(err)
+	}
+	//Для чужой ссылки история не найдется
+	_, err := t.primary.GetHistory(ctx, id, user)
+	return err == nil, ignoreExpected(err)
+}
+
+// isMoved проверяет что запись журнала уже есть в основном хранилище с тем же ID, URL и владельцем
+func (t *Tiered) isMoved(ctx context.Context, rec storage.Record) (bool, error) {
+	link, err := t.primary.GetLink(ctx, rec.ShortID)
+	if err != nil || link.OriginalURL != rec.OriginalURL {
+		return false, ignoreExpected(err)
+	}
+	//Для чужой ссылки история не найдется
+	_, err = t.primary.GetHistory(ctx, rec.ShortID, rec.User)
+	return err == nil, ignoreExpected(err)
+}
+
+// ignoreExpected убирает штатные ответы хранилища, оставляя только сбои
+func ignoreExpected(err error) error {
+	if storage.IsExpected(err) {
+		return nil
+	}
+	return err
+}
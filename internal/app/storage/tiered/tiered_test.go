@@ -0,0 +1,370 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"github.com/olkonon/shortener/internal/app/common"
+	"github.com/olkonon/shortener/internal/app/idgen"
+	"github.com/olkonon/shortener/internal/app/storage"
+	"github.com/olkonon/shortener/internal/app/storage/file"
+	"github.com/olkonon/shortener/internal/app/storage/memory"
+	"github.com/olkonon/shortener/internal/app/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	logrus.SetOutput(io.Discard)
+}
+
+var errConnRefused = errors.New("dial tcp: connection refused")
+
+// flakyStore хранилище в памяти, которое можно "отключить"
+type flakyStore struct {
+	*memory.InMemory
+	isDown atomic.Bool
+}
+
+func (fs *flakyStore) GenIDByURL(ctx context.Context, url string, user string, opts storage.SaveOptions) (string, error) {
+	if fs.isDown.Load() {
+		return "", errConnRefused
+	}
+	return fs.InMemory.GenIDByURL(ctx, url, user, opts)
+}
+
+func (fs *flakyStore) BatchSave(ctx context.Context, data []storage.BatchSaveRequest, user string) ([]storage.BatchSaveResponse, error) {
+	if fs.isDown.Load() {
+		return nil, errConnRefused
+	}
+	return fs.InMemory.BatchSave(ctx, data, user)
+}
+
+func (fs *flakyStore) GetURLByID(ctx context.Context, id string) (string, error) {
+	if fs.isDown.Load() {
+		return "", errConnRefused
+	}
+	return fs.InMemory.GetURLByID(ctx, id)
+}
+
+func (fs *flakyStore) GetLink(ctx context.Context, id string) (storage.Link, error) {
+	if fs.isDown.Load() {
+		return storage.Link{}, errConnRefused
+	}
+	return fs.InMemory.GetLink(ctx, id)
+}
+
+func (fs *flakyStore) BatchDelete(ctx context.Context, data []string, user string) {
+	//Недоступное хранилище теряет удаление
+	if !fs.isDown.Load() {
+		fs.InMemory.BatchDelete(ctx, data, user)
+	}
+}
+
+func (fs *flakyStore) GetHistory(ctx context.Context, id string, user string) ([]storage.HistoryRecord, error) {
+	if fs.isDown.Load() {
+		return nil, errConnRefused
+	}
+	return fs.InMemory.GetHistory(ctx, id, user)
+}
+
+func (fs *flakyStore) Import(ctx context.Context, data []storage.Record) (int, error) {
+	if fs.isDown.Load() {
+		return 0, errConnRefused
+	}
+	return fs.InMemory.Import(ctx, data)
+}
+
+func (fs *flakyStore) GetByUser(ctx context.Context, user string) ([]storage.UserRecord, error) {
+	if fs.isDown.Load() {
+		return nil, errConnRefused
+	}
+	return fs.InMemory.GetByUser(ctx, user)
+}
+
+func newTestTiered(t *testing.T, filename string) (*Tiered, *flakyStore) {
+	gen, err := idgen.NewRandom(idgen.DefaultRandomLength)
+	require.NoError(t, err)
+	return newTestTieredGen(t, filename, idgen.NewHash(common.GenHashedString), gen)
+}
+
+func newTestTieredGen(t *testing.T, filename string, primaryGen idgen.IDGenerator, fallbackGen idgen.IDGenerator) (*Tiered, *flakyStore) {
+	primary := &flakyStore{InMemory: memory.NewInMemory(primaryGen)}
+	//Перенос журнала в тестах запускается явно
+	tr := New(primary, file.NewFileStorage(filename, idgen.NewPrefixed(idgen.FallbackPrefix, fallbackGen)), 10, time.Hour)
+	t.Cleanup(func() {
+		primary.isDown.Store(false)
+		err := tr.Close()
+		require.NoError(t, err)
+		err = os.Remove(filename)
+		require.NoError(t, err)
+		os.Remove(filename + deletesSuffix)
+	})
+	return tr, primary
+}
+
+func TestTiered_Outage(t *testing.T) {
+	tr, primary := newTestTiered(t, "3A2B1C0D-9E8F-4A7B-8C6D-5E4F3A2B1C0D")
+	ctx := context.Background()
+
+	primary.isDown.Store(true)
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	batch, err := tr.BatchSave(ctx, []storage.BatchSaveRequest{{CorrelationID: "1", OriginalURL: "https://test2.com"}}, common.TestUser)
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+	//ID журнала не пересекаются с ID основного хранилища
+	assert.True(t, strings.HasPrefix(id, idgen.FallbackPrefix))
+	assert.True(t, strings.HasPrefix(batch[0].ShortID, idgen.FallbackPrefix))
+
+	//Во время сбоя ссылки обслуживаются из журнала
+	got, err := tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+
+	//После восстановления ссылки до переноса видны через журнал
+	primary.isDown.Store(false)
+	got, err = tr.GetURLByID(ctx, batch[0].ShortID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+	list, err := tr.GetByUser(ctx, common.TestUser)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tr.fallback.Records())
+
+	//Ссылки перенесены с теми же ID
+	got, err = primary.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	got, err = primary.GetURLByID(ctx, batch[0].ShortID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+	list, err = tr.GetByUser(ctx, common.TestUser)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestTiered_Stale(t *testing.T) {
+	tr, primary := newTestTiered(t, "4B3C2D1E-0F9A-4B8C-9D7E-6F5A4B3C2D1E")
+	ctx := context.Background()
+
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	_, err = tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+
+	primary.isDown.Store(true)
+	got, err := tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+
+	//Ссылка, по которой не было переходов, недоступна
+	_, err = tr.GetURLByID(ctx, "q3-report")
+	assert.ErrorIs(t, err, errConnRefused)
+
+	//Удаленная ссылка не обслуживается из кэша
+	tr.BatchDelete(ctx, []string{id}, common.TestUser)
+	_, err = tr.GetURLByID(ctx, id)
+	assert.ErrorIs(t, err, errConnRefused)
+}
+
+func TestTiered_Stale_MaxClicks(t *testing.T) {
+	tr, primary := newTestTiered(t, "8F7A6B5C-4D3E-4F2A-9B1C-0D9E8F7A6B5C")
+	ctx := context.Background()
+
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{MaxClicks: 1})
+	require.NoError(t, err)
+	got, err := tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	_, err = tr.GetLink(ctx, id)
+	require.NoError(t, err)
+
+	//Исчерпанная одноразовая ссылка не обслуживается из кэша во время сбоя
+	primary.isDown.Store(true)
+	_, err = tr.GetURLByID(ctx, id)
+	assert.ErrorIs(t, err, errConnRefused)
+	_, err = tr.GetLink(ctx, id)
+	assert.ErrorIs(t, err, errConnRefused)
+}
+
+func TestTiered_Stale_Expiration(t *testing.T) {
+	tr, primary := newTestTiered(t, "9A8B7C6D-5E4F-4A3B-8C2D-1E0F9A8B7C6D")
+	ctx := context.Background()
+
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{
+		ExpiresAt: time.Now().Add(100 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	_, err = tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+
+	primary.isDown.Store(true)
+	got, err := tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+
+	//После истечения срока ссылка не обслуживается из кэша
+	time.Sleep(100 * time.Millisecond)
+	_, err = tr.GetURLByID(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+}
+
+func TestTiered_Replay(t *testing.T) {
+	tr, primary := newTestTiered(t, "5C4D3E2F-1A0B-4C9D-8E7F-6A5B4C3D2E1F")
+	ctx := context.Background()
+
+	existsID, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	primary.isDown.Store(true)
+	//Журнал не знает о ссылке в основном хранилище и выдает новый ID
+	conflictID, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, existsID, conflictID)
+	id, err := tr.GenIDByURL(ctx, "https://test2.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	//При недоступном хранилище журнал сохраняется
+	err = tr.Replay(ctx)
+	assert.ErrorIs(t, err, errConnRefused)
+	assert.Len(t, tr.fallback.Records(), 2)
+
+	primary.isDown.Store(false)
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	//Конфликтующая ссылка уже выдана пользователю, поэтому остается в журнале
+	records := tr.fallback.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, conflictID, records[0].ID)
+	got, err := tr.GetURLByID(ctx, conflictID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	got, err = tr.GetURLByID(ctx, existsID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tr.fallback.Records(), 1)
+	got, err = primary.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+}
+
+func TestTiered_Replay_DeletedPrimaryLink(t *testing.T) {
+	tr, primary := newTestTiered(t, "7E6F5A4B-3C2D-4E1F-8A9B-0C1D2E3F4A5C")
+	ctx := context.Background()
+
+	deletedID, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	tr.BatchDelete(ctx, []string{deletedID}, common.TestUser)
+	storagetest.WaitDeleted(t, primary, deletedID)
+
+	primary.isDown.Store(true)
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, deletedID, id)
+
+	//Перенос не восстанавливает удаленную пользователем ссылку, выданный из журнала ID остается в журнале
+	primary.isDown.Store(false)
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	records := tr.fallback.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, id, records[0].ID)
+	got, err := tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test.com", got)
+	_, err = primary.GetLink(ctx, deletedID)
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+}
+
+func TestTiered_Replay_Clicks(t *testing.T) {
+	tr, primary := newTestTiered(t, "0B9C8D7E-6F5A-4B4C-9D3E-2F1A0B9C8D7E")
+	ctx := context.Background()
+
+	primary.isDown.Store(true)
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = tr.GetURLByID(ctx, id)
+	require.NoError(t, err)
+
+	//Переходы, учтенные журналом во время сбоя, переносятся вместе со ссылкой
+	primary.isDown.Store(false)
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tr.fallback.Records())
+	_, err = tr.GetURLByID(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+}
+
+func TestTiered_Replay_Deletes(t *testing.T) {
+	filename := "1C0D9E8F-7A6B-4C5D-8E4F-3A2B1C0D9E8F"
+	tr, primary := newTestTiered(t, filename)
+	ctx := context.Background()
+
+	id, err := tr.GenIDByURL(ctx, "https://test.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+	otherID, err := tr.GenIDByURL(ctx, "https://test2.com", "other-user", storage.SaveOptions{})
+	require.NoError(t, err)
+	resavedID, err := tr.GenIDByURL(ctx, "https://test3.com", common.TestUser, storage.SaveOptions{})
+	require.NoError(t, err)
+
+	//Удаления во время сбоя теряются основным хранилищем, но остаются в журнале удалений
+	primary.isDown.Store(true)
+	tr.BatchDelete(ctx, []string{id, otherID, resavedID}, common.TestUser)
+	primary.isDown.Store(false)
+	_, err = primary.GetLink(ctx, id)
+	require.NoError(t, err)
+	deletes, err := openDeleteJournal(filename + deletesSuffix)
+	require.NoError(t, err)
+	assert.Len(t, deletes.snapshot(), 3)
+
+	//Повторное сохранение отменяет удаление
+	_, err = tr.GenIDByURL(ctx, "https://test3.com", common.TestUser, storage.SaveOptions{})
+	assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	storagetest.WaitDeleted(t, primary, id)
+	got, err := primary.GetURLByID(ctx, otherID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test2.com", got)
+	got, err = primary.GetURLByID(ctx, resavedID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://test3.com", got)
+
+	//Подтвержденные удаления убираются из журнала
+	err = tr.Replay(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tr.deletes.snapshot())
+	deletes, err = openDeleteJournal(filename + deletesSuffix)
+	require.NoError(t, err)
+	assert.Empty(t, deletes.snapshot())
+}
+
+func TestTiered_Conformance(t *testing.T) {
+	filename := "6D5E4F3A-2B1C-4D0E-9F8A-7B6C5D4E3F2A"
+	newGenStore := func(t *testing.T, gen idgen.IDGenerator) storage.Storage {
+		//Сбой генератора основного хранилища переводит запись в журнал, поэтому генератор общий
+		tr, _ := newTestTieredGen(t, filename, gen, gen)
+		return tr
+	}
+	newStore := func(t *testing.T) storage.Storage {
+		tr, _ := newTestTiered(t, filename)
+		return tr
+	}
+	storagetest.Run(t, newStore)
+	storagetest.RunBatchAtomicity(t, newGenStore)
+	storagetest.RunPurge(t, newStore)
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTransferMismatch говорит о том что после переноса часть записей источника не найдена в приемнике
+var ErrTransferMismatch = errors.New("transferred records mismatch")
+
+// Record полная запись хранилища для переноса между хранилищами
+type Record struct {
+	ShortID     string
+	OriginalURL string
+	User        string
+	IsDeleted   bool
+	//DeletedAt время удаления, нулевое для ссылок, удаленных до учета этого времени
+	DeletedAt time.Time
+	ExpiresAt time.Time
+	MaxClicks int
+	Clicks    int
+	//History прежние адреса ссылки в порядке замены
+	History []HistoryRecord
+}
+
+// Exporter хранилище, все записи которого можно выгрузить
+type Exporter interface {
+	//Export передает f каждую запись хранилища, включая удаленные, ошибка f прерывает выгрузку
+	Export(ctx context.Context, f func(rec Record) error) error
+}
+
+// Importer хранилище, в которое можно загрузить записи с сохранением ID
+type Importer interface {
+	//Import сохраняет записи как есть и возвращает количество добавленных.
+	//Записи, чей ID или пара пользователь и URL уже заняты, пропускаются.
+	//Удаленным записям без DeletedAt временем удаления считается время загрузки
+	Import(ctx context.Context, data []Record) (int, error)
+}
+
+// ImportWrapped передает загрузку хранилищу store, обернутому декоратором. Для хранилища без поддержки загрузки - ошибка
+func ImportWrapped(ctx context.Context, store Storage, data []Record) (int, error) {
+	importer, isImporter := store.(Importer)
+	if !isImporter {
+		return 0, fmt.Errorf("storage %T does not support import", store)
+	}
+	return importer.Import(ctx, data)
+}
+
+// TransferReport результат переноса записей между хранилищами
+type TransferReport struct {
+	//Read количество записей в источнике, Deleted - из них удаленных
+	Read    int
+	Deleted int
+	//Imported количество добавленных в приемник записей
+	Imported int
+	//Verified количество записей источника, найденных в приемнике с тем же владельцем, URL и признаком удаления
+	Verified int
+}
+
+// transferKey данные записи, которые должны совпасть в источнике и приемнике
+type transferKey struct {
+	user      string
+	url       string
+	isDeleted bool
+}
+
+// Transfer переносит все записи из src в dst пачками по batchSize и сверяет результат.
+// При dryRun записи только читаются и проверяются, dst не используется
+func Transfer(ctx context.Context, src Exporter, dst Importer, batchSize int, dryRun bool) (TransferReport, error) {
+	var report TransferReport
+	if batchSize <= 0 {
+		return report, fmt.Errorf("bad batch size %d", batchSize)
+	}
+	var verifier Exporter
+	if !dryRun {
+		var ok bool
+		if verifier, ok = dst.(Exporter); !ok {
+			return report, errors.New("destination storage does not support export, result can't be verified")
+		}
+	}
+
+	source := make(map[string]transferKey)
+	batch := make([]Record, 0, batchSize)
+	flush := func() error {
+		if dryRun || len(batch) == 0 {
+			batch = batch[:0]
+			return nil
+		}
+		imported, err := dst.Import(ctx, batch)
+		report.Imported += imported
+		batch = batch[:0]
+		return err
+	}
+
+	err := src.Export(ctx, func(rec Record) error {
+		if rec.ShortID == "" || rec.User == "" {
+			return fmt.Errorf("record %q of user %q: empty short ID or user", rec.ShortID, rec.User)
+		}
+		if err := CheckURL(rec.OriginalURL); err != nil {
+			return fmt.Errorf("record %q: %w", rec.ShortID, err)
+		}
+		if _, isExists := source[rec.ShortID]; isExists {
+			return fmt.Errorf("record %q: duplicate short ID", rec.ShortID)
+		}
+		source[rec.ShortID] = transferKey{user: rec.User, url: rec.OriginalURL, isDeleted: rec.IsDeleted}
+		report.Read++
+		if rec.IsDeleted {
+			report.Deleted++
+		}
+
+		batch = append(batch, rec)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil || dryRun {
+		return report, err
+	}
+
+	err = verifier.Export(ctx, func(rec Record) error {
+		if key, isExists := source[rec.ShortID]; isExists && key == (transferKey{user: rec.User, url: rec.OriginalURL, isDeleted: rec.IsDeleted}) {
+			report.Verified++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if report.Verified != report.Read {
+		return report, fmt.Errorf("%w: %d of %d records not found in destination", ErrTransferMismatch, report.Read-report.Verified, report.Read)
+	}
+	return report, nil
+}
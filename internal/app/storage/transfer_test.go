@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+// mapStore хранилище записей по ID для проверки переноса
+type mapStore struct {
+	records map[string]Record
+	batches int
+}
+
+func (ms *mapStore) Export(_ context.Context, f func(rec Record) error) error {
+	ids := make([]string, 0, len(ms.records))
+	for id := range ms.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := f(ms.records[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *mapStore) Import(_ context.Context, data []Record) (int, error) {
+	ms.batches++
+	imported := 0
+	for _, rec := range data {
+		if _, isExists := ms.records[rec.ShortID]; isExists {
+			continue
+		}
+		ms.records[rec.ShortID] = rec
+		imported++
+	}
+	return imported, nil
+}
+
+func newMapStore(records ...Record) *mapStore {
+	ms := &mapStore{records: make(map[string]Record)}
+	for _, rec := range records {
+		ms.records[rec.ShortID] = rec
+	}
+	return ms
+}
+
+func TestTransfer(t *testing.T) {
+	src := newMapStore(
+		Record{ShortID: "a", OriginalURL: "https://a.com", User: "u1"},
+		Record{ShortID: "b", OriginalURL: "https://b.com", User: "u1", IsDeleted: true},
+		Record{ShortID: "c", OriginalURL: "https://c.com", User: "u2", MaxClicks: 3, Clicks: 1},
+	)
+
+	t.Run("dry run", func(t *testing.T) {
+		dst := newMapStore()
+		report, err := Transfer(context.Background(), src, dst, 2, true)
+		require.NoError(t, err)
+		assert.Equal(t, TransferReport{Read: 3, Deleted: 1}, report)
+		assert.Empty(t, dst.records)
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		dst := newMapStore()
+		report, err := Transfer(context.Background(), src, dst, 2, false)
+		require.NoError(t, err)
+		assert.Equal(t, TransferReport{Read: 3, Deleted: 1, Imported: 3, Verified: 3}, report)
+		assert.Equal(t, src.records, dst.records)
+		assert.Equal(t, 2, dst.batches)
+
+		//Повторный перенос ничего не добавляет и успешно сверяется
+		report, err = Transfer(context.Background(), src, dst, 2, false)
+		require.NoError(t, err)
+		assert.Equal(t, TransferReport{Read: 3, Deleted: 1, Imported: 0, Verified: 3}, report)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		dst := newMapStore(Record{ShortID: "a", OriginalURL: "https://other.com", User: "u3"})
+		report, err := Transfer(context.Background(), src, dst, 10, false)
+		assert.ErrorIs(t, err, ErrTransferMismatch)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, 2, report.Verified)
+	})
+
+	t.Run("bad record", func(t *testing.T) {
+		bad := newMapStore(Record{ShortID: "a", OriginalURL: "https://a.com"})
+		_, err := Transfer(context.Background(), bad, newMapStore(), 10, false)
+		assert.Error(t, err)
+	})
+
+	t.Run("export error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		_, err := Transfer(context.Background(), exportFunc(func(context.Context, func(Record) error) error {
+			return errStop
+		}), newMapStore(), 10, false)
+		assert.ErrorIs(t, err, errStop)
+	})
+}
+
+type exportFunc func(ctx context.Context, f func(rec Record) error) error
+
+func (ef exportFunc) Export(ctx context.Context, f func(rec Record) error) error {
+	return ef(ctx, f)
+}